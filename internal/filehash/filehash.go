@@ -0,0 +1,24 @@
+// Package filehash hashes the contents of files, to detect when they change.
+package filehash
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Sum returns the SHA-256 hash of the file contents.
+func Sum(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return h.Sum(nil), nil
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"runtime"
 
+	"github.com/carolynvs/magex/pkg"
 	"github.com/carolynvs/magex/pkg/downloads"
 	"github.com/carolynvs/magex/xplat"
 	"github.com/mholt/archiver/v3"
@@ -26,19 +27,80 @@ type DownloadArchiveOptions struct {
 	TargetFileTemplate string
 }
 
-// DownloadToGopathBin downloads an archived file to GOPATH/bin.
+// DownloadToGopathBin downloads an archived file to GOPATH/bin. Use
+// DownloadToDir to download to another directory.
 func DownloadToGopathBin(opts DownloadArchiveOptions) error {
+	downloadOpts, err := opts.toDownloadOptions()
+	if err != nil {
+		return err
+	}
+
+	return downloads.DownloadToGopathBin(downloadOpts)
+}
+
+// DownloadToDir downloads an archived file to the destination directory, such
+// as ./bin, creating the directory if needed and adding it to PATH.
+func DownloadToDir(destDir string, opts DownloadArchiveOptions) error {
+	downloadOpts, err := opts.toDownloadOptions()
+	if err != nil {
+		return err
+	}
+
+	return downloads.DownloadToDir(destDir, downloadOpts)
+}
+
+// EnsureDownloadOptions are the set of options that can be passed to EnsureDownload.
+type EnsureDownloadOptions struct {
+	DownloadArchiveOptions
+
+	// AllowedVersion is a semver range that specifies which versions are acceptable
+	// if found. For example, ^1.2.3 or 2.x. When unspecified, Version is used as the
+	// minimum version and sets the allowed major version. For example, a Version of
+	// 1.2.3 would result in an AllowedVersion of ^1.2.3. When neither is a semver
+	// value, any installed version is acceptable.
+	AllowedVersion string
+
+	// VersionCommand is the arguments to pass to the CLI to determine the installed version.
+	// For example, "version" or "--version". When unspecified the CLI is called without any arguments.
+	VersionCommand string
+
+	// Destination is the location where the CLI should be installed, which is
+	// added to PATH. Defaults to GOPATH/bin. Using ./bin is recommended to
+	// require build tools without modifying the host environment.
+	Destination string
+}
+
+// EnsureDownload checks if the command is installed with an allowed version,
+// and if it is missing or the installed version is not allowed, downloads the
+// archive and extracts the specified version to the destination.
+func EnsureDownload(opts EnsureDownloadOptions) error {
+	downloadOpts, err := opts.toDownloadOptions()
+	if err != nil {
+		return err
+	}
+
+	return pkg.EnsureDownload(pkg.EnsureDownloadOptions{
+		DownloadOptions: downloadOpts,
+		AllowedVersion:  opts.AllowedVersion,
+		VersionCommand:  opts.VersionCommand,
+		Destination:     opts.Destination,
+	})
+}
+
+// toDownloadOptions returns the options to download the archive, and extract
+// the binary from it.
+func (opts DownloadArchiveOptions) toDownloadOptions() (downloads.DownloadOptions, error) {
 	// determine the appropriate file extension based on the OS, e.g. windows gets .zip, otherwise .tgz
 	opts.Ext = opts.ArchiveExtensions[runtime.GOOS]
 	if opts.Ext == "" {
-		return fmt.Errorf("no archive file extension was specified for the current GOOS (%s)", runtime.GOOS)
+		return downloads.DownloadOptions{}, fmt.Errorf("no archive file extension was specified for the current GOOS (%s)", runtime.GOOS)
 	}
 
 	if opts.Hook == nil {
 		opts.Hook = ExtractBinaryFromArchiveHook(opts)
 	}
 
-	return downloads.DownloadToGopathBin(opts.DownloadOptions)
+	return opts.DownloadOptions, nil
 }
 
 // ExtractBinaryFromArchiveHook is the default hook for DownloadToGopathBin.
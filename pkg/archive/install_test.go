@@ -1,18 +1,27 @@
 package archive
 
 import (
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"testing"
 
+	"github.com/carolynvs/magex/pkg"
 	"github.com/carolynvs/magex/pkg/downloads"
 	"github.com/carolynvs/magex/pkg/gopath"
+	"github.com/carolynvs/magex/pkg/internal/tooltest"
 	"github.com/carolynvs/magex/xplat"
 	"github.com/magefile/mage/mg"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	tooltest.Main(m)
+}
+
 func TestDownloadArchiveToGopathBin_OsReplacement(t *testing.T) {
 	if runtime.GOARCH != "amd64" {
 		t.Skipf("skipping test since gh only has binaries for amd64")
@@ -79,3 +88,85 @@ func TestDownloadArchiveToGopathBin(t *testing.T) {
 	_, err = exec.LookPath("helm" + xplat.FileExt())
 	require.NoError(t, err)
 }
+
+// toolDownloadOptions returns the options to download a version of the fake
+// tool in an archive from the server.
+func toolDownloadOptions(url string, version string) DownloadArchiveOptions {
+	return DownloadArchiveOptions{
+		DownloadOptions: tooltest.DownloadOptions(url, version),
+		ArchiveExtensions: map[string]string{
+			"linux":  ".tar.gz",
+			"darwin": ".tar.gz",
+		},
+		TargetFileTemplate: "mytool{{.EXT}}",
+	}
+}
+
+func TestEnsureDownload(t *testing.T) {
+	svr, downloadCount := tooltest.NewServer(t)
+
+	err, cleanup := gopath.UseTempGopath()
+	require.NoError(t, err, "Failed to set up a temporary GOPATH")
+	defer cleanup()
+
+	opts := EnsureDownloadOptions{
+		DownloadArchiveOptions: toolDownloadOptions(svr.URL, "v1.2.0"),
+		VersionCommand:         "--version",
+	}
+
+	// Not installed
+	require.NoError(t, EnsureDownload(opts))
+	assert.Equal(t, 1, *downloadCount)
+
+	// Installed version is allowed
+	require.NoError(t, EnsureDownload(opts))
+	assert.Equal(t, 1, *downloadCount, "the allowed version should not be downloaded again")
+
+	// Installed version is not allowed
+	opts.Version = "v1.3.0"
+	opts.AllowedVersion = "^1.3.0"
+	require.NoError(t, EnsureDownload(opts))
+	assert.Equal(t, 2, *downloadCount)
+
+	installedVersion, err := pkg.GetCommandVersion("mytool", "--version")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.3.0", installedVersion)
+}
+
+func TestEnsureDownload_IntoDirectory(t *testing.T) {
+	svr, _ := tooltest.NewServer(t)
+
+	err, cleanup := gopath.UseTempGopath()
+	require.NoError(t, err, "Failed to set up a temporary GOPATH")
+	defer cleanup()
+
+	tmpBin, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpBin)
+
+	opts := EnsureDownloadOptions{
+		DownloadArchiveOptions: toolDownloadOptions(svr.URL, "v1.2.0"),
+		VersionCommand:         "--version",
+		Destination:            tmpBin,
+	}
+	require.NoError(t, EnsureDownload(opts))
+	assert.FileExists(t, filepath.Join(tmpBin, "mytool"+xplat.FileExt()))
+	assert.True(t, xplat.InPath(tmpBin), "the destination should be added to PATH")
+}
+
+func TestDownloadToDir(t *testing.T) {
+	svr, _ := tooltest.NewServer(t)
+
+	tmpBin, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpBin)
+
+	origPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", origPath)
+
+	err = DownloadToDir(filepath.Join(tmpBin, "bin"), toolDownloadOptions(svr.URL, "v1.2.0"))
+	require.NoError(t, err)
+
+	_, err = exec.LookPath("mytool" + xplat.FileExt())
+	require.NoError(t, err, "the destination should be added to PATH")
+}
@@ -0,0 +1,208 @@
+package downloads
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+const (
+	// CacheEnvVar is the environment variable that overrides the directory
+	// where downloaded files are cached. Set it to "off" to disable the cache.
+	// For example, point it at a directory that is saved and restored
+	// between CI runs.
+	CacheEnvVar = "MAGEX_DOWNLOAD_CACHE"
+
+	// DefaultCacheMaxSize is the maximum size of the default cache, 1GiB.
+	DefaultCacheMaxSize int64 = 1 << 30
+)
+
+// Cache stores downloaded files, keyed by the URL and the checksum of the
+// file when known, so that they are not downloaded again.
+type Cache struct {
+	// Dir is the directory where files are cached.
+	Dir string
+
+	// MaxSize is the maximum size of the cached files in bytes. When exceeded,
+	// the least recently used files are removed. When 0, the cache is not limited.
+	MaxSize int64
+}
+
+// DefaultCache returns the cache used when DownloadOptions.Cache is not set,
+// which is located in the user's cache directory, e.g. ~/.cache/magex/downloads
+// on Linux, unless overridden with CacheEnvVar. Returns nil when the cache is
+// disabled, or the user's cache directory cannot be determined.
+func DefaultCache() *Cache {
+	dir := os.Getenv(CacheEnvVar)
+	if dir == "off" {
+		return nil
+	}
+
+	if dir == "" {
+		userCache, err := os.UserCacheDir()
+		if err != nil {
+			if mg.Verbose() {
+				log.Printf("not caching downloads: %s\n", err)
+			}
+			return nil
+		}
+		dir = filepath.Join(userCache, "magex", "downloads")
+	}
+
+	return &Cache{Dir: dir, MaxSize: DefaultCacheMaxSize}
+}
+
+// cacheKey identifies a downloaded file in the cache.
+func cacheKey(src string, sum string) string {
+	h := sha256.New()
+	io.WriteString(h, src)
+	if sum != "" {
+		io.WriteString(h, "\n"+sum)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// entryDir is the directory containing the cached file for the key.
+func (c *Cache) entryDir(key string) string {
+	return filepath.Join(c.Dir, key)
+}
+
+// Get copies the cached file for the key to dest, returning false when the
+// file is not cached.
+func (c *Cache) Get(key string, dest string) (bool, error) {
+	dir := c.entryDir(key)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 || !entries[0].Mode().IsRegular() {
+		return false, nil
+	}
+	cached := filepath.Join(dir, entries[0].Name())
+
+	if err := copyFile(cached, dest); err != nil {
+		return false, fmt.Errorf("could not copy %s from the download cache: %w", cached, err)
+	}
+
+	// Record when the file was used, so that the least recently used files are evicted first
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return true, nil
+}
+
+// Put stores a copy of the file in the cache, and then evicts the least
+// recently used files when the cache is larger than MaxSize.
+func (c *Cache) Put(key string, src string) error {
+	dir := c.entryDir(key)
+	if err := os.MkdirAll(c.Dir, 0755); err != nil {
+		return fmt.Errorf("could not create the download cache directory %s: %w", c.Dir, err)
+	}
+
+	// Populate a temporary directory and rename it, so that the cache never
+	// has a partially written file
+	tmpDir, err := ioutil.TempDir(c.Dir, ".tmp-"+key)
+	if err != nil {
+		return fmt.Errorf("could not write to the download cache: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := copyFile(src, filepath.Join(tmpDir, filepath.Base(src))); err != nil {
+		return fmt.Errorf("could not write %s to the download cache: %w", src, err)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("could not replace %s in the download cache: %w", dir, err)
+	}
+	if err := os.Rename(tmpDir, dir); err != nil {
+		return fmt.Errorf("could not write %s to the download cache: %w", src, err)
+	}
+
+	return c.evict(key)
+}
+
+// Remove deletes the cached file for the key.
+func (c *Cache) Remove(key string) error {
+	return os.RemoveAll(c.entryDir(key))
+}
+
+// evict removes the least recently used files until the cache is no larger
+// than MaxSize, keeping the file for the specified key.
+func (c *Cache) evict(keep string) error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+
+	type cacheEntry struct {
+		key      string
+		size     int64
+		lastUsed time.Time
+	}
+
+	dirs, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return fmt.Errorf("could not read the download cache: %w", err)
+	}
+
+	var total int64
+	entries := make([]cacheEntry, 0, len(dirs))
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != sha256.Size*2 {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(c.Dir, dir.Name()))
+		if err != nil {
+			continue
+		}
+
+		entry := cacheEntry{key: dir.Name(), lastUsed: dir.ModTime()}
+		for _, f := range files {
+			entry.size += f.Size()
+		}
+		total += entry.size
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.Before(entries[j].lastUsed)
+	})
+	for _, entry := range entries {
+		if total <= c.MaxSize {
+			break
+		}
+		if entry.key == keep {
+			continue
+		}
+		if mg.Verbose() {
+			log.Printf("evicting %s from the download cache\n", entry.key)
+		}
+		if err := c.Remove(entry.key); err != nil {
+			return fmt.Errorf("could not evict %s from the download cache: %w", entry.key, err)
+		}
+		total -= entry.size
+	}
+	return nil
+}
+
+func copyFile(src string, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,294 @@
+package downloads
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/carolynvs/magex/xplat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCache creates an empty cache in a temporary directory.
+func newTestCache(t *testing.T) *Cache {
+	dir, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return &Cache{Dir: dir}
+}
+
+// countingServer serves the contents and counts the number of requests.
+func countingServer(contents string) (*httptest.Server, *int32) {
+	var count int32
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&count, 1)
+		w.Write([]byte(contents))
+	}))
+	return svr, &count
+}
+
+// testDownload downloads mybin to a temporary directory, without the cache,
+// and returns the contents of the downloaded file. When a handler is
+// specified, the file is downloaded from a server that uses the handler.
+// Retries do not wait unless RetryDelay is set.
+func testDownload(t *testing.T, handler http.HandlerFunc, opts DownloadOptions) (string, error) {
+	if handler != nil {
+		svr := httptest.NewServer(handler)
+		defer svr.Close()
+		opts.UrlTemplate = svr.URL + "/mybin"
+	}
+
+	dest, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dest) })
+
+	opts.Name = "mybin"
+	opts.NoCache = true
+	if opts.RetryDelay == 0 {
+		opts.RetryDelay = time.Millisecond
+	}
+	if err := Download(dest, opts); err != nil {
+		return "", err
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dest, "mybin"+xplat.FileExt()))
+	require.NoError(t, err)
+	return string(got), nil
+}
+
+func TestDownload_Cache(t *testing.T) {
+	t.Run("cached", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/mybin",
+			Name:        "mybin",
+			Cache:       newTestCache(t),
+		}
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, os.Remove(filepath.Join(dest, "mybin"+xplat.FileExt())))
+
+		// Download again without the server, to simulate being offline
+		svr.Close()
+		require.NoError(t, Download(dest, opts), "the file should be downloaded from the cache")
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+
+		contents, err := ioutil.ReadFile(filepath.Join(dest, "mybin"+xplat.FileExt()))
+		require.NoError(t, err)
+		assert.Equal(t, "echo ok", string(contents))
+	})
+
+	t.Run("no cache", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/mybin",
+			Name:        "mybin",
+			Cache:       newTestCache(t),
+			NoCache:     true,
+		}
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, Download(dest, opts))
+		assert.Equal(t, int32(2), atomic.LoadInt32(count))
+
+		entries, err := ioutil.ReadDir(opts.Cache.Dir)
+		require.NoError(t, err)
+		assert.Empty(t, entries, "nothing should be cached")
+	})
+
+	t.Run("keyed by checksum", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/mybin",
+			Name:        "mybin",
+			Cache:       newTestCache(t),
+		}
+		require.NoError(t, Download(dest, opts))
+
+		opts.Checksum = sha256Hex("echo ok")
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, Download(dest, opts))
+		assert.Equal(t, int32(2), atomic.LoadInt32(count), "the file should be downloaded again when the checksum is specified")
+	})
+
+	t.Run("corrupt cache", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/mybin",
+			Name:        "mybin",
+			Checksum:    sha256Hex("echo ok"),
+			Cache:       newTestCache(t),
+		}
+		require.NoError(t, Download(dest, opts))
+
+		// Tamper with the cached file
+		key := cacheKey(svr.URL+"/mybin", "sha256:"+sha256Hex("echo ok"))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(opts.Cache.entryDir(key), "mybin"), []byte("tampered"), 0644))
+
+		require.NoError(t, Download(dest, opts), "the file should be downloaded again")
+		assert.Equal(t, int32(2), atomic.LoadInt32(count))
+	})
+
+	t.Run("checksum file is cached", func(t *testing.T) {
+		var count int32
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			if r.URL.Path == "/checksums.txt" {
+				w.Write([]byte(sha256Hex("echo ok") + "  mybin\n"))
+				return
+			}
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate:         svr.URL + "/mybin",
+			ChecksumUrlTemplate: svr.URL + "/checksums.txt",
+			Name:                "mybin",
+			Cache:               newTestCache(t),
+		}
+		require.NoError(t, Download(dest, opts))
+		svr.Close()
+		require.NoError(t, Download(dest, opts), "the download should work offline")
+		assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	})
+}
+
+func TestCache_Evict(t *testing.T) {
+	cache := newTestCache(t)
+	cache.MaxSize = 10
+
+	src, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	put := func(key string, contents string, lastUsed time.Time) {
+		file := filepath.Join(src, "file")
+		require.NoError(t, ioutil.WriteFile(file, []byte(contents), 0644))
+		require.NoError(t, cache.Put(key, file))
+		require.NoError(t, os.Chtimes(cache.entryDir(key), lastUsed, lastUsed))
+	}
+
+	now := time.Now()
+	keyA, keyB, keyC := cacheKey("a", ""), cacheKey("b", ""), cacheKey("c", "")
+	put(keyA, "aaaa", now.Add(-3*time.Hour))
+	put(keyB, "bbbb", now.Add(-time.Hour))
+
+	// Use A, so that B is the least recently used
+	found, err := cache.Get(keyA, filepath.Join(src, "a"))
+	require.NoError(t, err)
+	require.True(t, found)
+
+	put(keyC, "cccc", now)
+	assert.DirExists(t, cache.entryDir(keyA))
+	assert.NoDirExists(t, cache.entryDir(keyB), "the least recently used file should be evicted")
+	assert.DirExists(t, cache.entryDir(keyC))
+}
+
+func TestDefaultCache(t *testing.T) {
+	defer os.Setenv(CacheEnvVar, os.Getenv(CacheEnvVar))
+
+	os.Setenv(CacheEnvVar, "off")
+	assert.Nil(t, DefaultCache())
+
+	os.Setenv(CacheEnvVar, "/tmp/cache")
+	cache := DefaultCache()
+	require.NotNil(t, cache)
+	assert.Equal(t, "/tmp/cache", cache.Dir)
+	assert.Equal(t, DefaultCacheMaxSize, cache.MaxSize)
+
+	os.Unsetenv(CacheEnvVar)
+	cache = DefaultCache()
+	require.NotNil(t, cache)
+	userCache, err := os.UserCacheDir()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(userCache, "magex", "downloads"), cache.Dir)
+}
+
+func TestDownload_DefaultCache(t *testing.T) {
+	t.Run("latest is not cached", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/{{.VERSION}}/mybin",
+			Name:        "mybin",
+			Version:     "latest",
+		}
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, Download(dest, opts))
+		assert.Equal(t, int32(2), atomic.LoadInt32(count), "a file without a checksum or pinned version should not be cached")
+	})
+
+	t.Run("pinned version is cached", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/{{.VERSION}}/mybin",
+			Name:        "mybin",
+			Version:     "v1.0.0",
+		}
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, Download(dest, opts))
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+	})
+
+	t.Run("checksum is cached", func(t *testing.T) {
+		svr, count := countingServer("echo ok")
+		defer svr.Close()
+
+		dest, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		defer os.RemoveAll(dest)
+
+		opts := DownloadOptions{
+			UrlTemplate: svr.URL + "/latest/mybin",
+			Name:        "mybin",
+			Checksum:    sha256Hex("echo ok"),
+		}
+		require.NoError(t, Download(dest, opts))
+		require.NoError(t, Download(dest, opts))
+		assert.Equal(t, int32(1), atomic.LoadInt32(count))
+	})
+}
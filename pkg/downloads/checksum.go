@@ -0,0 +1,233 @@
+package downloads
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checksum is an expected digest of a file, such as sha256:abc123.
+type checksum struct {
+	Algorithm string
+	Digest    string
+}
+
+func (c checksum) String() string {
+	return c.Algorithm + ":" + c.Digest
+}
+
+// parseChecksum parses a checksum, with an optional algorithm prefix, e.g.
+// sha256:abc123. When the algorithm is not specified, it is detected from
+// the length of the digest.
+func parseChecksum(value string) (checksum, error) {
+	value = strings.TrimSpace(value)
+	algorithm := ""
+	if i := strings.IndexAny(value, ":="); i >= 0 {
+		algorithm = strings.ToLower(value[:i])
+		value = value[i+1:]
+	}
+	digest := strings.ToLower(value)
+
+	if _, err := hex.DecodeString(digest); err != nil || digest == "" {
+		return checksum{}, fmt.Errorf("invalid checksum %q, expected a hex encoded sha256 or sha512 digest", value)
+	}
+
+	switch algorithm {
+	case "":
+		switch len(digest) {
+		case sha256.Size * 2:
+			algorithm = "sha256"
+		case sha512.Size * 2:
+			algorithm = "sha512"
+		default:
+			return checksum{}, fmt.Errorf("invalid checksum %q, expected a sha256 or sha512 digest", value)
+		}
+	case "sha256", "sha512":
+		h, _ := newHash(algorithm)
+		if len(digest) != h.Size()*2 {
+			return checksum{}, fmt.Errorf("invalid %s checksum %q, expected %d hex characters", algorithm, value, h.Size()*2)
+		}
+	default:
+		return checksum{}, fmt.Errorf("unsupported checksum algorithm %q, only sha256 and sha512 are supported", algorithm)
+	}
+
+	return checksum{Algorithm: algorithm, Digest: digest}, nil
+}
+
+func newHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+}
+
+// verifyChecksum compares the file against the expected checksum, returning an
+// error when they do not match.
+func verifyChecksum(file string, src string, want checksum) error {
+	h, err := newHash(want.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("could not open %s to verify its checksum: %w", file, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("could not read %s to verify its checksum: %w", file, err)
+	}
+
+	got := hex.EncodeToString(h.Sum(nil))
+	if got != want.Digest {
+		return fmt.Errorf("checksum mismatch for %s: expected %s:%s but got %s:%s", src, want.Algorithm, want.Digest, want.Algorithm, got)
+	}
+	return nil
+}
+
+// getChecksum returns the expected checksum of the file downloaded from the
+// first of srcs, either from opts.Checksum or the checksum file at
+// opts.ChecksumUrlTemplate, which is downloaded to tmpDir. The checksum file
+// is downloaded from the mirrors of the file in srcs when it is in the same
+// directory as the file. Returns false when no checksum was specified.
+func getChecksum(srcs []string, opts DownloadOptions, tmpDir string) (checksum, bool, error) {
+	src := srcs[0]
+	if opts.Checksum != "" {
+		sum, err := parseChecksum(opts.Checksum)
+		return sum, true, err
+	}
+
+	if opts.ChecksumUrlTemplate == "" {
+		return checksum{}, false, nil
+	}
+
+	checksumUrl, err := RenderTemplate(opts.ChecksumUrlTemplate, opts)
+	if err != nil {
+		return checksum{}, false, err
+	}
+
+	checksumDir := filepath.Join(tmpDir, "checksums")
+	if err := os.Mkdir(checksumDir, 0755); err != nil {
+		return checksum{}, false, fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	checksumFile := filepath.Join(checksumDir, downloadFileName(checksumUrl))
+
+	cache := selectCache(opts, opts.ChecksumUrlTemplate, false)
+	key := cacheKey(checksumUrl, "")
+	checksumUrls := append([]string{checksumUrl}, mirrorChecksumURLs(srcs, checksumUrl)...)
+	cached, err := fetch(checksumUrls, checksumFile, opts, cache, key)
+	if err != nil {
+		return checksum{}, false, err
+	}
+
+	contents, err := ioutil.ReadFile(checksumFile)
+	if err != nil {
+		return checksum{}, false, fmt.Errorf("could not read the checksums from %s: %w", redactURL(checksumUrl), err)
+	}
+
+	sum, err := findChecksum(contents, downloadFileName(src))
+	if err != nil {
+		if cached {
+			cache.Remove(key)
+		}
+		return checksum{}, false, fmt.Errorf("invalid checksum file %s: %w", redactURL(checksumUrl), err)
+	}
+
+	if cache != nil && !cached {
+		if err := cache.Put(key, checksumFile); err != nil {
+			log.Printf("WARNING: %s\n", err)
+		}
+	}
+	return sum, true, nil
+}
+
+// mirrorChecksumURLs returns the URLs of the checksum file on the mirrors of
+// the downloaded file, where the first of srcs is the original URL of the file,
+// when the checksum file is in the same directory as the file, or below it.
+// For example, the checksum file for https://example.com/v1/mybin at
+// https://example.com/v1/checksums.txt is https://mirror.example.com/v1/checksums.txt
+// on the mirror https://mirror.example.com/v1/mybin.
+func mirrorChecksumURLs(srcs []string, checksumUrl string) []string {
+	base := srcs[0][:strings.LastIndex(srcs[0], "/")+1]
+	if base == "" || !strings.HasPrefix(checksumUrl, base) {
+		return nil
+	}
+	rel := strings.TrimPrefix(checksumUrl, base)
+
+	urls := make([]string, 0, len(srcs)-1)
+	for _, mirror := range srcs[1:] {
+		urls = append(urls, mirror[:strings.LastIndex(mirror, "/")+1]+rel)
+	}
+	return urls
+}
+
+// findChecksum finds the checksum for a file in the contents of a checksum
+// file. Supports the output of sha256sum, e.g. checksums.txt, the BSD format
+// "SHA256 (file) = digest", and files that only contain a digest, e.g.
+// file.sha256.
+func findChecksum(contents []byte, fileName string) (checksum, error) {
+	var entries int
+	var onlyDigest string
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries++
+
+		// BSD format: SHA256 (file) = digest
+		if open := strings.Index(line, " ("); open > 0 && strings.Contains(line, ") = ") {
+			end := strings.LastIndex(line, ") = ")
+			if line[open+2:end] == fileName {
+				return parseChecksum(line[:open] + ":" + line[end+4:])
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 1 {
+			onlyDigest = fields[0]
+			continue
+		}
+
+		// GNU format: digest  file, where the file has a * prefix in binary mode
+		name := strings.TrimPrefix(strings.Join(fields[1:], " "), "*")
+		if path.Base(name) == fileName {
+			return parseChecksum(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return checksum{}, err
+	}
+
+	if entries == 1 && onlyDigest != "" {
+		return parseChecksum(onlyDigest)
+	}
+	return checksum{}, fmt.Errorf("no checksum found for %s", fileName)
+}
+
+// downloadFileName returns the name of the file downloaded from the URL.
+func downloadFileName(src string) string {
+	if u, err := url.Parse(src); err == nil && u.Path != "" {
+		return path.Base(u.Path)
+	}
+	return path.Base(src)
+}
@@ -0,0 +1,171 @@
+package downloads
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/carolynvs/magex/xplat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(contents string) string {
+	sum := sha256.Sum256([]byte(contents))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParseChecksum(t *testing.T) {
+	digest256 := sha256Hex("echo ok")
+	sum512 := sha512.Sum512([]byte("echo ok"))
+	digest512 := hex.EncodeToString(sum512[:])
+
+	testcases := []struct {
+		name    string
+		value   string
+		want    checksum
+		wantErr string
+	}{
+		{name: "sha256", value: digest256, want: checksum{"sha256", digest256}},
+		{name: "sha512", value: digest512, want: checksum{"sha512", digest512}},
+		{name: "prefix", value: "sha256:" + digest256, want: checksum{"sha256", digest256}},
+		{name: "uppercase", value: "SHA256:" + strings.ToUpper(digest256), want: checksum{"sha256", digest256}},
+		{name: "wrong length", value: "sha512:" + digest256, wantErr: "expected 128 hex characters"},
+		{name: "unknown length", value: "abc123", wantErr: "expected a sha256 or sha512 digest"},
+		{name: "not hex", value: "xyz", wantErr: "expected a hex encoded"},
+		{name: "unsupported algorithm", value: "md5:abc123", wantErr: "unsupported checksum algorithm"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseChecksum(tc.value)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	digestA := sha256Hex("a")
+	digestB := sha256Hex("b")
+
+	testcases := []struct {
+		name     string
+		contents string
+		want     string
+		wantErr  string
+	}{
+		{name: "sha256sum", contents: fmt.Sprintf("%s  mybin-linux.tar.gz\n%s  mybin-darwin.tar.gz\n", digestA, digestB), want: digestB},
+		{name: "binary mode", contents: fmt.Sprintf("%s *mybin-linux.tar.gz\n%s *mybin-darwin.tar.gz\n", digestA, digestB), want: digestB},
+		{name: "nested path", contents: fmt.Sprintf("%s  dist/mybin-darwin.tar.gz\n", digestB), want: digestB},
+		{name: "bsd", contents: fmt.Sprintf("SHA256 (mybin-linux.tar.gz) = %s\nSHA256 (mybin-darwin.tar.gz) = %s\n", digestA, digestB), want: digestB},
+		{name: "only digest", contents: digestB + "\n", want: digestB},
+		{name: "missing", contents: fmt.Sprintf("%s  mybin-linux.tar.gz\n", digestA), wantErr: "no checksum found for mybin-darwin.tar.gz"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := findChecksum([]byte(tc.contents), "mybin-darwin.tar.gz")
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got.Digest)
+		})
+	}
+}
+
+func TestMirrorChecksumURLs(t *testing.T) {
+	srcs := []string{"https://example.com/v1/mybin", "https://mirror.example.com/releases/v1/mybin"}
+
+	testcases := []struct {
+		name        string
+		checksumUrl string
+		want        []string
+	}{
+		{name: "same directory", checksumUrl: "https://example.com/v1/checksums.txt", want: []string{"https://mirror.example.com/releases/v1/checksums.txt"}},
+		{name: "subdirectory", checksumUrl: "https://example.com/v1/sums/mybin.sha256", want: []string{"https://mirror.example.com/releases/v1/sums/mybin.sha256"}},
+		{name: "other directory", checksumUrl: "https://example.com/checksums.txt", want: nil},
+		{name: "other host", checksumUrl: "https://checksums.example.com/v1/checksums.txt", want: nil},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := mirrorChecksumURLs(srcs, tc.checksumUrl)
+			if tc.want == nil {
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDownload_Checksum(t *testing.T) {
+	const contents = "echo ok"
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mybin":
+			w.Write([]byte(contents))
+		case "/checksums.txt":
+			fmt.Fprintf(w, "%s  other\n%s  mybin\n", sha256Hex("other"), sha256Hex(contents))
+		case "/bad-checksums.txt":
+			fmt.Fprintf(w, "%s  mybin\n", sha256Hex("tampered"))
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer svr.Close()
+
+	testcases := []struct {
+		name    string
+		opts    DownloadOptions
+		wantErr string
+	}{
+		{name: "checksum", opts: DownloadOptions{Checksum: "sha256:" + sha256Hex(contents)}},
+		{name: "checksum mismatch", opts: DownloadOptions{Checksum: sha256Hex("tampered")}, wantErr: "checksum mismatch for " + svr.URL + "/mybin: expected sha256:" + sha256Hex("tampered") + " but got sha256:" + sha256Hex(contents)},
+		{name: "checksum file", opts: DownloadOptions{ChecksumUrlTemplate: svr.URL + "/checksums.txt"}},
+		{name: "checksum file mismatch", opts: DownloadOptions{ChecksumUrlTemplate: svr.URL + "/bad-checksums.txt"}, wantErr: "checksum mismatch"},
+		{name: "checksum file missing", opts: DownloadOptions{ChecksumUrlTemplate: svr.URL + "/missing.txt"}, wantErr: "404 Not Found"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dest, err := ioutil.TempDir("", "magex")
+			require.NoError(t, err)
+			defer os.RemoveAll(dest)
+
+			hookCalled := false
+			opts := tc.opts
+			opts.UrlTemplate = svr.URL + "/mybin"
+			opts.Name = "mybin"
+			opts.Hook = func(archivePath string) (string, error) {
+				hookCalled = true
+				return archivePath, nil
+			}
+
+			err = Download(dest, opts)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				assert.False(t, hookCalled, "the hook should not be called when the checksum does not match")
+				assert.NoFileExists(t, filepath.Join(dest, "mybin"+xplat.FileExt()))
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, hookCalled)
+			assert.FileExists(t, filepath.Join(dest, "mybin"+xplat.FileExt()))
+		})
+	}
+}
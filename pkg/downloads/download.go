@@ -2,19 +2,39 @@ package downloads
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/carolynvs/magex/pkg/gopath"
-	"github.com/carolynvs/magex/shx"
 	"github.com/carolynvs/magex/xplat"
+	"github.com/magefile/mage/mg"
+)
+
+const (
+	// DefaultTimeout is the maximum amount of time for each attempt to download a file.
+	DefaultTimeout = 10 * time.Minute
+
+	// DefaultRetries is the number of times a failed download is retried.
+	DefaultRetries = 3
+
+	// DefaultRetryDelay is how long to wait before the first retry, which
+	// doubles after each attempt.
+	DefaultRetryDelay = time.Second
+
+	// maxRetryDelay is the maximum amount of time to wait between retries.
+	maxRetryDelay = 30 * time.Second
 )
 
 // PostDownloadHook is the handler called after downloading a file, which returns the absolute path to the binary.
@@ -23,16 +43,17 @@ type PostDownloadHook func(archivePath string) (string, error)
 // DownloadOptions is the configuration settings used to download a file.
 type DownloadOptions struct {
 	// UrlTemplate is the Go template for the URL to download. Required.
-	// Available Template Variables:
-	//   - {{.GOOS}}
-	//   - {{.GOARCH}}
-	//   - {{.EXT}}
-	//   - {{.VERSION}}
+	// See RenderTemplate for the available template variables and functions.
 	UrlTemplate string
 
 	// Name of the binary, excluding OS specific file extension. Required.
 	Name string
 
+	// MirrorUrlTemplates are Go templates for alternate URLs of the file, which
+	// are tried in order when it cannot be downloaded from UrlTemplate.
+	// Supports the same template variables as UrlTemplate. Optional.
+	MirrorUrlTemplates []string
+
 	// Version to replace {{.VERSION}} in the URL template. Optional depending on whether or not the version is in the UrlTemplate.
 	Version string
 
@@ -45,6 +66,83 @@ type DownloadOptions struct {
 	// ArchReplacement maps from a GOARCH to the arch keyword used for the download. Optional, defaults to empty.
 	ArchReplacement map[string]string
 
+	// Checksum is the expected checksum of the downloaded file, which is
+	// verified before the Hook is called. The algorithm may be specified with
+	// a prefix, e.g. sha256:abc123 or sha512:abc123, otherwise it is detected
+	// from the length of the digest. Optional.
+	Checksum string
+
+	// ChecksumUrlTemplate is the Go template for the URL of a checksum file
+	// containing the checksum of the downloaded file, such as checksums.txt or
+	// {{.VERSION}}/mybin{{.EXT}}.sha256. Supports the same template variables
+	// as UrlTemplate. The file may contain checksums for multiple files, in the
+	// format output by sha256sum, and the entry matching the name of the
+	// downloaded file is used. When the checksum file is in the same directory
+	// as the file, it is downloaded from the MirrorUrlTemplates as well.
+	// Ignored when Checksum is set. Optional.
+	ChecksumUrlTemplate string
+
+	// Cache stores downloaded files, so that they are not downloaded again.
+	// Every file is cached when set, even files that may change, such as the
+	// latest release. Optional, defaults to DefaultCache(), which only caches
+	// files with a known checksum, or a URL with a pinned version.
+	Cache *Cache
+
+	// NoCache always downloads the file, without using the cache.
+	NoCache bool
+
+	// Headers are additional HTTP headers sent when downloading the file and
+	// its checksum file, for example an API key for a private repository.
+	// Headers and credentials are only sent to the hosts of UrlTemplate and
+	// ChecksumUrlTemplate, and not to mirrors on other hosts. Optional.
+	Headers map[string]string
+
+	// BearerTokenEnvVar is the name of an environment variable containing a
+	// token sent in the Authorization header, such as GITHUB_TOKEN. The file
+	// is downloaded without authentication when the variable is not set.
+	// Optional.
+	BearerTokenEnvVar string
+
+	// BasicAuthUsernameEnvVar is the name of an environment variable
+	// containing the username for HTTP basic authentication. Optional.
+	BasicAuthUsernameEnvVar string
+
+	// BasicAuthPasswordEnvVar is the name of an environment variable
+	// containing the password for HTTP basic authentication. Optional.
+	BasicAuthPasswordEnvVar string
+
+	// Client is the HTTP client used to download the file, for example to use
+	// a proxy or a custom CA bundle. Optional, defaults to http.DefaultClient.
+	Client *http.Client
+
+	// Progress displays the progress of the download. Optional, defaults to
+	// DefaultProgressReporter().
+	Progress ProgressReporter
+
+	// NoProgress disables displaying the progress of the download.
+	NoProgress bool
+
+	// Timeout is the maximum amount of time for each attempt to download a
+	// file. When an attempt times out, the next attempt resumes the download
+	// where it left off, if supported by the server. Optional, defaults to
+	// DefaultTimeout.
+	Timeout time.Duration
+
+	// Retries is the number of times a download is retried after a network
+	// error or a 5xx response from the server. Optional, defaults to
+	// DefaultRetries. Set to -1 to disable retries.
+	Retries int
+
+	// RetryDelay is how long to wait before retrying a failed download, which
+	// doubles after each attempt. Optional, defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+
+	// VerifyCommand is the arguments to pass to the binary after it is
+	// installed, such as "--version", to verify that it works. When it fails,
+	// the previously installed binary is restored. Optional, when unspecified
+	// the binary is not run.
+	VerifyCommand string
+
 	// Hook to call after downloading the file.
 	Hook PostDownloadHook
 }
@@ -54,11 +152,9 @@ type DownloadOptions struct {
 // - version is the version to substitute into the template
 // - ext is the file extension to substitute into the template
 //
-// Template Variables:
-// - {{.GOOS}}
-// - {{.GOARCH}}
-// - {{.EXT}}
-// - {{.VERSION}}
+// See RenderTemplate for the available template variables and functions.
+//
+// Use DownloadToDir, or Download, to download to another directory.
 func DownloadToGopathBin(opts DownloadOptions) error {
 
 	if err := gopath.EnsureGopathBin(); err != nil {
@@ -68,12 +164,51 @@ func DownloadToGopathBin(opts DownloadOptions) error {
 	return Download(bin, opts)
 }
 
+// DownloadToDir downloads a file to the destination directory, such as ./bin,
+// creating the directory if needed and adding it to PATH.
+// See RenderTemplate for the template variables and functions available to
+// opts.UrlTemplate.
+func DownloadToDir(destDir string, opts DownloadOptions) error {
+	dest, err := EnsureBinDir(destDir)
+	if err != nil {
+		return err
+	}
+	return Download(dest, opts)
+}
+
+// EnsureBinDir creates the directory if needed and adds it to the beginning of
+// PATH when it is not already in PATH, returning the absolute path to the
+// directory.
+func EnsureBinDir(dir string) (string, error) {
+	dest, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("error converting %s to an absolute path: %w", dir, err)
+	}
+	if err := os.MkdirAll(dest, 0755); err != nil {
+		return "", fmt.Errorf("could not create %s: %w", dest, err)
+	}
+	xplat.EnsureInPath(dest)
+	return dest, nil
+}
+
+// Download a file to the destination directory.
 func Download(destDir string, opts DownloadOptions) error {
+	if err := validateAuth(opts); err != nil {
+		return err
+	}
+
 	src, err := RenderTemplate(opts.UrlTemplate, opts)
 	if err != nil {
 		return err
 	}
-	log.Printf("Downloading %s...", src)
+	srcs := []string{src}
+	for _, mirrorTemplate := range opts.MirrorUrlTemplates {
+		mirror, err := RenderTemplate(mirrorTemplate, opts)
+		if err != nil {
+			return err
+		}
+		srcs = append(srcs, mirror)
+	}
 
 	// Download to a temp file
 	tmpDir, err := ioutil.TempDir("", "magex")
@@ -83,27 +218,42 @@ func Download(destDir string, opts DownloadOptions) error {
 	defer os.RemoveAll(tmpDir)
 	tmpFile := filepath.Join(tmpDir, filepath.Base(src))
 
-	r, err := http.Get(src)
+	sum, hasChecksum, err := getChecksum(srcs, opts, tmpDir)
 	if err != nil {
-		return fmt.Errorf("could not resolve %s: %w", src, err)
+		return err
 	}
-	defer r.Body.Close()
-	if r.StatusCode >= 400 {
-		return fmt.Errorf("error downloading %s (%d): %s", src, r.StatusCode, r.Status)
+	cache := selectCache(opts, opts.UrlTemplate, hasChecksum)
+	key := cacheKey(src, "")
+	if hasChecksum {
+		key = cacheKey(src, sum.String())
 	}
 
-	f, err := os.OpenFile(tmpFile, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+	cached, err := fetch(srcs, tmpFile, opts, cache, key)
 	if err != nil {
-		return fmt.Errorf("could not open %s: %w", tmpFile, err)
+		return err
 	}
-	defer f.Close()
 
-	// Download to the temp file
-	_, err = io.Copy(f, r.Body)
-	if err != nil {
-		return fmt.Errorf("error downloading %s: %w", src, err)
+	// Verify the file before using it
+	if hasChecksum {
+		err = verifyChecksum(tmpFile, redactURL(src), sum)
+		if err != nil && cached {
+			log.Printf("The cached download of %s is invalid, downloading it again: %s\n", redactURL(src), err)
+			cache.Remove(key)
+			cached = false
+			if err = downloadFile(srcs, tmpFile, opts); err == nil {
+				err = verifyChecksum(tmpFile, redactURL(src), sum)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if cache != nil && !cached {
+		if err := cache.Put(key, tmpFile); err != nil {
+			log.Printf("WARNING: %s\n", err)
+		}
 	}
-	f.Close()
 
 	// Call a hook to allow for extracting or modifying the downloaded file
 	var tmpBin = tmpFile
@@ -114,56 +264,347 @@ func Download(destDir string, opts DownloadOptions) error {
 		}
 	}
 
-	// Make the binary executable
-	err = os.Chmod(tmpBin, 0755)
+	// Move it to the destination
+	destPath := filepath.Join(destDir, opts.Name+xplat.FileExt())
+	return installBinary(tmpBin, destPath, opts.VerifyCommand)
+}
+
+// selectCache returns the cache for a file downloaded from the URL template,
+// or nil when the file should not be cached. The default cache is only used
+// when the file cannot change without the cache key changing too, so that
+// files such as the latest release are not cached forever.
+func selectCache(opts DownloadOptions, urlTemplate string, hasChecksum bool) *Cache {
+	if opts.NoCache {
+		return nil
+	}
+	if opts.Cache != nil {
+		return opts.Cache
+	}
+	if !hasChecksum && !isPinnedVersion(urlTemplate, opts.Version) {
+		if mg.Verbose() {
+			log.Printf("not caching the download because it does not have a checksum or a pinned version\n")
+		}
+		return nil
+	}
+	return DefaultCache()
+}
+
+// isPinnedVersion returns if the URL template is rendered with a specific
+// version, instead of a version that changes, such as latest.
+func isPinnedVersion(urlTemplate string, version string) bool {
+	if version == "" || strings.EqualFold(version, "latest") {
+		return false
+	}
+	return strings.Contains(urlTemplate, ".VERSION")
+}
+
+// fetch downloads the file to the destination, using the cached file when
+// available, and returns if the cached file was used. The file is cached by
+// its first URL, so the cached file is used regardless of which mirror it was
+// downloaded from.
+func fetch(srcs []string, dest string, opts DownloadOptions, cache *Cache, key string) (bool, error) {
+	if cache != nil {
+		cached, err := cache.Get(key, dest)
+		if err != nil {
+			return false, err
+		}
+		if cached {
+			log.Printf("Using cached download of %s", redactURL(srcs[0]))
+			return true, nil
+		}
+	}
+
+	return false, downloadFile(srcs, dest, opts)
+}
+
+// downloadFile downloads the file to the destination, trying each URL in
+// order, along with the mirrors from MirrorEnvVar, until one succeeds.
+func downloadFile(srcs []string, dest string, opts DownloadOptions) error {
+	urls, err := withMirrors(srcs)
 	if err != nil {
-		return fmt.Errorf("could not make %s executable: %w", tmpBin, err)
+		return err
 	}
 
-	// Move it to the destination
-	destPath := filepath.Join(destDir, opts.Name+xplat.FileExt())
-	if err := shx.Copy(tmpBin, destPath); err != nil {
-		return fmt.Errorf("error copying %s to %s: %w", tmpBin, destPath, err)
+	var failures []string
+	for i, src := range urls {
+		err := downloadURL(src, dest, opts)
+		if err == nil {
+			return nil
+		}
+		if len(urls) == 1 {
+			return err
+		}
+
+		failures = append(failures, fmt.Sprintf("  - %s: %s", redactURL(src), err))
+		if i < len(urls)-1 {
+			log.Printf("WARNING: %s, trying %s\n", err, redactURL(urls[i+1]))
+		}
+	}
+	return fmt.Errorf("could not download %s from any of the %d urls tried:\n%s",
+		downloadFileName(srcs[0]), len(urls), strings.Join(failures, "\n"))
+}
+
+// downloadURL downloads the URL to the destination file, retrying failed
+// attempts with an increasing delay between them.
+func downloadURL(src string, dest string, opts DownloadOptions) error {
+	log.Printf("Downloading %s...", redactURL(src))
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %w", dest, err)
+	}
+	defer f.Close()
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	delay := opts.RetryDelay
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+
+	var reporter ProgressReporter
+	if !opts.NoProgress {
+		reporter = opts.Progress
+		if reporter == nil {
+			reporter = DefaultProgressReporter()
+		}
+	}
+	progress := newProgressTracker(reporter, src)
+
+	for attempt := 0; ; attempt++ {
+		retry, err := downloadAttempt(src, f, opts, timeout, progress)
+		if err == nil {
+			progress.finish(nil)
+			return f.Close()
+		}
+		if !retry || attempt >= retries {
+			progress.finish(err)
+			return err
+		}
+
+		log.Printf("%s, retrying in %s...", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// downloadAttempt downloads the URL to the file, resuming the download from
+// the end of the file when it was partially downloaded by a previous attempt.
+// Returns if the download should be retried when it fails.
+func downloadAttempt(src string, f *os.File, opts DownloadOptions, timeout time.Duration, progress *progressTracker) (bool, error) {
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return false, fmt.Errorf("could not seek to the end of %s: %w", f.Name(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := newRequest(ctx, src, opts)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	// Only refer to the redacted url in errors and logs from here on
+	src = redactURL(src)
+
+	r, err := client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("could not resolve %s: %w", src, err)
+	}
+	defer r.Body.Close()
+
+	switch {
+	case r.StatusCode == http.StatusPartialContent && offset > 0:
+		if start, ok := contentRangeStart(r); !ok || start != offset {
+			// The server returned a different part of the file, start over
+			return true, restartDownload(f, fmt.Errorf("could not resume downloading %s: unexpected Content-Range %q", src, r.Header.Get("Content-Range")))
+		}
+		if mg.Verbose() {
+			log.Printf("Resuming download of %s at %d bytes", src, offset)
+		}
+	case r.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		return true, restartDownload(f, fmt.Errorf("could not resume downloading %s: %s", src, r.Status))
+	case r.StatusCode >= 500 || r.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("error downloading %s (%d): %s", src, r.StatusCode, r.Status)
+	case r.StatusCode >= 400:
+		return false, fmt.Errorf("error downloading %s (%d): %s", src, r.StatusCode, r.Status)
+	case offset > 0:
+		// The server does not support resuming downloads, start over
+		if err := restartDownload(f, nil); err != nil {
+			return false, err
+		}
+	}
+
+	if r.StatusCode != http.StatusPartialContent {
+		offset = 0
+	}
+	total := int64(-1)
+	if r.ContentLength >= 0 {
+		total = offset + r.ContentLength
+	}
+	progress.begin(offset, total)
+
+	// Keep what was downloaded when the connection fails, so that the next attempt can resume
+	_, err = io.Copy(io.MultiWriter(f, progress), r.Body)
+	if err != nil {
+		return true, fmt.Errorf("error downloading %s: %w", src, err)
+	}
+	return false, nil
+}
+
+// newRequest creates a request for the URL with the headers and credentials
+// from the download options, which are only sent to the hosts of UrlTemplate
+// and ChecksumUrlTemplate, and not to mirrors on other hosts.
+func newRequest(ctx context.Context, src string, opts DownloadOptions) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download url %s: %w", redactURL(src), err)
+	}
+
+	if !isOriginalHost(req.URL, opts) {
+		if mg.Verbose() && hasCredentials(opts) {
+			log.Printf("not sending the headers and credentials to %s, which is not the host of the download url\n", req.URL.Host)
+		}
+		return req, nil
+	}
+
+	for key, value := range opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if opts.BearerTokenEnvVar != "" {
+		if token := os.Getenv(opts.BearerTokenEnvVar); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		} else if mg.Verbose() {
+			log.Printf("%s is not set, downloading %s without a bearer token\n", opts.BearerTokenEnvVar, redactURL(src))
+		}
+	}
+
+	if opts.BasicAuthUsernameEnvVar != "" || opts.BasicAuthPasswordEnvVar != "" {
+		username := os.Getenv(opts.BasicAuthUsernameEnvVar)
+		password := os.Getenv(opts.BasicAuthPasswordEnvVar)
+		if username != "" || password != "" {
+			req.SetBasicAuth(username, password)
+		} else if mg.Verbose() {
+			log.Printf("%s and %s are not set, downloading %s without basic authentication\n",
+				opts.BasicAuthUsernameEnvVar, opts.BasicAuthPasswordEnvVar, redactURL(src))
+		}
+	}
+
+	return req, nil
+}
+
+// isOriginalHost returns if the URL has the same host as the rendered
+// UrlTemplate or ChecksumUrlTemplate.
+func isOriginalHost(u *url.URL, opts DownloadOptions) bool {
+	for _, tmpl := range []string{opts.UrlTemplate, opts.ChecksumUrlTemplate} {
+		if tmpl == "" {
+			continue
+		}
+		src, err := RenderTemplate(tmpl, opts)
+		if err != nil {
+			continue
+		}
+		if original, err := url.Parse(src); err == nil && strings.EqualFold(original.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCredentials returns if headers or credentials are configured.
+func hasCredentials(opts DownloadOptions) bool {
+	return len(opts.Headers) > 0 || opts.BearerTokenEnvVar != "" ||
+		opts.BasicAuthUsernameEnvVar != "" || opts.BasicAuthPasswordEnvVar != ""
+}
+
+// validateAuth checks that only one type of authentication is configured.
+func validateAuth(opts DownloadOptions) error {
+	if opts.BearerTokenEnvVar != "" && (opts.BasicAuthUsernameEnvVar != "" || opts.BasicAuthPasswordEnvVar != "") {
+		return errors.New("invalid DownloadOptions: BearerTokenEnvVar cannot be used with basic authentication")
 	}
 	return nil
 }
 
+// redactURL replaces the password in a URL, so that it is not logged.
+func redactURL(src string) string {
+	u, err := url.Parse(src)
+	if err != nil {
+		return src
+	}
+	return u.Redacted()
+}
+
+// restartDownload discards the partially downloaded file, returning the
+// specified error unless the file could not be truncated.
+func restartDownload(f *os.File, reason error) error {
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("could not truncate %s: %w", f.Name(), err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("could not seek to the start of %s: %w", f.Name(), err)
+	}
+	return reason
+}
+
+// contentRangeStart returns the first byte of a partial response from the
+// Content-Range header, e.g. bytes 100-199/200.
+func contentRangeStart(r *http.Response) (int64, bool) {
+	value := strings.TrimPrefix(r.Header.Get("Content-Range"), "bytes ")
+	i := strings.Index(value, "-")
+	if i < 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(value[:i], 10, 64)
+	return start, err == nil
+}
+
 // RenderTemplate takes a Go templated string and expands template variables
 // Available Template Variables:
 // - {{.GOOS}}
+// - {{.GOOS_TITLE}}, e.g. Linux
 // - {{.GOARCH}}
+// - {{.UNAME_ARCH}}, e.g. x86_64 or aarch64
+// - {{.GOAMD64}}, e.g. v3, from the GOAMD64 environment variable, defaulting to v1
+// - {{.GOARM}}, e.g. 7, from the GOARM environment variable, defaulting to 7
+// - {{.LIBC}}, musl or gnu on Linux
 // - {{.EXT}}
 // - {{.VERSION}}
+// - {{.VERSION_NUMBER}}, the version without the v prefix
+//
+// Available Template Functions:
+// - trimPrefix, e.g. {{.VERSION | trimPrefix "v"}}
+// - title, e.g. {{title .GOOS}}
+// - lower, e.g. {{lower .GOOS_TITLE}}
 func RenderTemplate(tmplContents string, opts DownloadOptions) (string, error) {
-	tmpl, err := template.New("url").Parse(tmplContents)
+	tmpl, err := template.New("url").Funcs(templateFuncs).Parse(tmplContents)
 	if err != nil {
-		return "", fmt.Errorf("error parsing %s as a Go template: %w", opts.UrlTemplate, err)
+		return "", fmt.Errorf("error parsing %s as a Go template: %w", tmplContents, err)
 	}
 
-	srcData := struct {
-		GOOS    string
-		GOARCH  string
-		EXT     string
-		VERSION string
-	}{
-		GOOS:    runtime.GOOS,
-		GOARCH:  runtime.GOARCH,
-		EXT:     opts.Ext,
-		VERSION: opts.Version,
-	}
-
-	if overrideGoos, ok := opts.OsReplacement[runtime.GOOS]; ok {
-		srcData.GOOS = overrideGoos
-	}
-
-	if overrideGoarch, ok := opts.ArchReplacement[runtime.GOARCH]; ok {
-		srcData.GOARCH = overrideGoarch
-	}
+	srcData := newTemplateData(opts)
 
 	buf := &bytes.Buffer{}
 	err = tmpl.Execute(buf, srcData)
 	if err != nil {
-		return "", fmt.Errorf("error rendering %s as a Go template with data: %#v: %w", opts.UrlTemplate, srcData, err)
+		return "", fmt.Errorf("error rendering %s as a Go template with data: %#v: %w", tmplContents, srcData, err)
 	}
 
 	return buf.String(), nil
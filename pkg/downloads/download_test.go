@@ -1,16 +1,23 @@
 package downloads
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/carolynvs/magex/pkg/gopath"
 	"github.com/carolynvs/magex/xplat"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestDownloadToGopathBin(t *testing.T) {
@@ -67,3 +74,303 @@ func TestDownload(t *testing.T) {
 		assert.FileExists(t, filepath.Join(dest, "mybin"+xplat.FileExt()))
 	})
 }
+
+func TestDownload_Retry(t *testing.T) {
+	const contents = "echo ok, this file is downloaded in pieces"
+
+	// writePartial sends the first half of the file and then drops the connection
+	writePartial := func(w http.ResponseWriter) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+		w.Write([]byte(contents[:len(contents)/2]))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	t.Run("server error", func(t *testing.T) {
+		var count int32
+		got, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&count, 1) < 3 {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(contents))
+		}, DownloadOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, contents, got)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	})
+
+	t.Run("retries exhausted", func(t *testing.T) {
+		var count int32
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, DownloadOptions{Retries: 2})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "503 Service Unavailable")
+		assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	})
+
+	t.Run("retries disabled", func(t *testing.T) {
+		var count int32
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, DownloadOptions{Retries: -1})
+		require.Error(t, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+
+	t.Run("client error is not retried", func(t *testing.T) {
+		var count int32
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&count, 1)
+			w.WriteHeader(http.StatusForbidden)
+		}, DownloadOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "403 Forbidden")
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		var ranges []string
+		got, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			ranges = append(ranges, r.Header.Get("Range"))
+			if len(ranges) == 1 {
+				writePartial(w)
+			}
+			http.ServeContent(w, r, "mybin", time.Time{}, strings.NewReader(contents))
+		}, DownloadOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, contents, got)
+		assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", len(contents)/2)}, ranges)
+	})
+
+	t.Run("resume not supported", func(t *testing.T) {
+		var count int32
+		got, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&count, 1) == 1 {
+				writePartial(w)
+			}
+			w.Write([]byte(contents))
+		}, DownloadOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, contents, got, "the partial download should be discarded")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		var count int32
+		got, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&count, 1) == 1 {
+				// Stall after sending part of the file
+				w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+				w.Write([]byte(contents[:len(contents)/2]))
+				w.(http.Flusher).Flush()
+				<-r.Context().Done()
+				return
+			}
+			http.ServeContent(w, r, "mybin", time.Time{}, strings.NewReader(contents))
+		}, DownloadOptions{Timeout: 100 * time.Millisecond})
+		require.NoError(t, err)
+		assert.Equal(t, contents, got)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+	})
+}
+
+func TestDownload_Auth(t *testing.T) {
+	// captureLogs returns the log output written by the test
+	captureLogs := func(t *testing.T) *bytes.Buffer {
+		logs := &bytes.Buffer{}
+		log.SetOutput(logs)
+		t.Cleanup(func() { log.SetOutput(os.Stderr) })
+		return logs
+	}
+
+	t.Run("headers", func(t *testing.T) {
+		var got http.Header
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate: svr.URL + "/mybin",
+			Headers:     map[string]string{"X-Api-Key": "secret"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "secret", got.Get("X-Api-Key"))
+	})
+
+	t.Run("bearer token", func(t *testing.T) {
+		os.Setenv("MAGEX_TEST_TOKEN", "secret-token")
+		defer os.Unsetenv("MAGEX_TEST_TOKEN")
+
+		var requests []string
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.URL.Path)
+			if r.Header.Get("Authorization") != "Bearer secret-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/checksums.txt" {
+				w.Write([]byte(sha256Hex("echo ok") + "  mybin\n"))
+				return
+			}
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		logs := captureLogs(t)
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:         svr.URL + "/mybin",
+			ChecksumUrlTemplate: svr.URL + "/checksums.txt",
+			BearerTokenEnvVar:   "MAGEX_TEST_TOKEN",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/checksums.txt", "/mybin"}, requests, "the token should be used for the checksum file too")
+		assert.NotContains(t, logs.String(), "secret-token")
+	})
+
+	t.Run("credentials are not sent to mirrors", func(t *testing.T) {
+		os.Setenv("MAGEX_TEST_TOKEN", "secret-token")
+		defer os.Unsetenv("MAGEX_TEST_TOKEN")
+
+		down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer down.Close()
+
+		var mirrorRequests int
+		mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mirrorRequests++
+			assert.Empty(t, r.Header.Get("Authorization"), "the mirror should not get the token")
+			assert.Empty(t, r.Header.Get("X-Api-Key"), "the mirror should not get the headers")
+			w.Write([]byte("echo ok"))
+		}))
+		defer mirror.Close()
+
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:        down.URL + "/mybin",
+			MirrorUrlTemplates: []string{mirror.URL + "/mybin"},
+			Headers:            map[string]string{"X-Api-Key": "secret"},
+			BearerTokenEnvVar:  "MAGEX_TEST_TOKEN",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, mirrorRequests)
+	})
+
+	t.Run("bearer token not set", func(t *testing.T) {
+		os.Unsetenv("MAGEX_TEST_TOKEN")
+
+		var got string
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("Authorization")
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:       svr.URL + "/mybin",
+			BearerTokenEnvVar: "MAGEX_TEST_TOKEN",
+		})
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("basic auth", func(t *testing.T) {
+		os.Setenv("MAGEX_TEST_USER", "me")
+		os.Setenv("MAGEX_TEST_PASSWORD", "secret-password")
+		defer os.Unsetenv("MAGEX_TEST_USER")
+		defer os.Unsetenv("MAGEX_TEST_PASSWORD")
+
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); !ok || user != "me" || password != "secret-password" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		logs := captureLogs(t)
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:             svr.URL + "/mybin",
+			BasicAuthUsernameEnvVar: "MAGEX_TEST_USER",
+			BasicAuthPasswordEnvVar: "MAGEX_TEST_PASSWORD",
+		})
+		require.NoError(t, err)
+		assert.NotContains(t, logs.String(), "secret-password")
+	})
+
+	t.Run("bearer token and basic auth", func(t *testing.T) {
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:             "https://example.com/mybin",
+			BearerTokenEnvVar:       "MAGEX_TEST_TOKEN",
+			BasicAuthUsernameEnvVar: "MAGEX_TEST_USER",
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "BearerTokenEnvVar cannot be used with basic authentication")
+	})
+
+	t.Run("password in url is redacted", func(t *testing.T) {
+		svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}))
+		defer svr.Close()
+
+		logs := captureLogs(t)
+		src := strings.Replace(svr.URL, "http://", "http://me:secret-password@", 1) + "/mybin"
+		_, err := testDownload(t, nil, DownloadOptions{UrlTemplate: src})
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "secret-password")
+		assert.NotContains(t, logs.String(), "secret-password")
+	})
+
+	t.Run("custom client", func(t *testing.T) {
+		svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("echo ok"))
+		}))
+		defer svr.Close()
+
+		_, err := testDownload(t, nil, DownloadOptions{UrlTemplate: svr.URL + "/mybin"})
+		require.Error(t, err, "the default client should not trust the test server's certificate")
+
+		_, err = testDownload(t, nil, DownloadOptions{UrlTemplate: svr.URL + "/mybin", Client: svr.Client()})
+		require.NoError(t, err)
+	})
+}
+
+func TestDownloadToDir(t *testing.T) {
+	origPath := os.Getenv("PATH")
+	defer os.Setenv("PATH", origPath)
+
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("echo ok"))
+	}))
+	defer svr.Close()
+
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmp)
+
+	// Download to a relative directory that does not exist yet
+	pwd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(tmp))
+	defer os.Chdir(pwd)
+
+	opts := DownloadOptions{
+		UrlTemplate: svr.URL,
+		Name:        "mybin",
+		NoCache:     true,
+	}
+	err = DownloadToDir("bin", opts)
+	require.NoError(t, err)
+
+	binDir, err := filepath.Abs("bin")
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(binDir, "mybin"+xplat.FileExt()))
+	assert.True(t, xplat.InPath(binDir), "the absolute path to the directory should be added to PATH")
+	assert.False(t, xplat.InPath("bin"), "the relative path should not be added to PATH")
+}
@@ -0,0 +1,124 @@
+package downloads
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/carolynvs/magex/shx"
+	"github.com/magefile/mage/mg"
+)
+
+// installBinary replaces the binary at dest with src, so that dest is never
+// left partially written, even when the copy fails or the binary is running.
+// The previous binary is kept as a backup until the new binary is verified,
+// by running it with verifyCommand when specified, and restored when the new
+// binary does not work.
+func installBinary(src string, dest string, verifyCommand string) error {
+	// Write to a temporary file in the same directory so that it can be renamed over dest
+	destDir, name := filepath.Split(dest)
+	tmp, err := ioutil.TempFile(destDir, "."+name+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create a temporary file in %s: %w", destDir, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeBinary(tmp, src); err != nil {
+		return fmt.Errorf("error copying %s to %s: %w", src, tmp.Name(), err)
+	}
+
+	backup := filepath.Join(destDir, "."+name+".backup")
+	hasBackup, err := backupBinary(dest, backup)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		if hasBackup {
+			restoreBinary(backup, dest)
+		}
+		return fmt.Errorf("could not install %s: %w", dest, err)
+	}
+
+	if verifyCommand != "" {
+		if err := shx.Command(dest, verifyCommand).CollapseArgs().RunE(); err != nil {
+			if hasBackup {
+				log.Printf("%s does not work, restoring the previous version\n", dest)
+				restoreBinary(backup, dest)
+			} else {
+				os.Remove(dest)
+			}
+			return fmt.Errorf("could not verify that %s works with '%s %s': %w", dest, name, verifyCommand, err)
+		}
+	}
+
+	if hasBackup {
+		if err := os.Remove(backup); err != nil && mg.Verbose() {
+			log.Printf("could not remove the previous version of %s: %s\n", dest, err)
+		}
+	}
+	return nil
+}
+
+// writeBinary copies the binary to the file and makes it executable.
+func writeBinary(f *os.File, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	defer in.Close()
+
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(f.Name(), 0755)
+}
+
+// backupBinary saves a copy of the binary at dest to backup, returning false
+// when there is no binary at dest.
+func backupBinary(dest string, backup string) (bool, error) {
+	if _, err := os.Stat(dest); os.IsNotExist(err) {
+		return false, nil
+	}
+
+	if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("could not remove the previous backup %s: %w", backup, err)
+	}
+
+	// A running binary cannot be replaced on Windows, but it can be moved
+	if runtime.GOOS == "windows" {
+		if err := os.Rename(dest, backup); err != nil {
+			return false, fmt.Errorf("could not back up %s: %w", dest, err)
+		}
+		return true, nil
+	}
+
+	// Link the backup so that dest is always present, falling back to a copy
+	// when the file system does not support hard links
+	if err := os.Link(dest, backup); err != nil {
+		if err := copyFile(dest, backup); err != nil {
+			return false, fmt.Errorf("could not back up %s: %w", dest, err)
+		}
+	}
+	return true, nil
+}
+
+// restoreBinary replaces dest with the backup.
+func restoreBinary(backup string, dest string) {
+	if err := os.Rename(backup, dest); err != nil {
+		log.Printf("WARNING: could not restore %s from %s: %s\n", dest, backup, err)
+	}
+}
@@ -0,0 +1,111 @@
+package downloads
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstallBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test binaries are shell scripts")
+	}
+
+	const (
+		oldBinary    = "#!/bin/sh\necho v1.0.0\n"
+		newBinary    = "#!/bin/sh\necho v2.0.0\n"
+		brokenBinary = "#!/bin/sh\nexit 1\n"
+	)
+
+	setup := func(t *testing.T, installed string, download string) (string, string) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err)
+		t.Cleanup(func() { os.RemoveAll(tmp) })
+
+		src := filepath.Join(tmp, "download")
+		require.NoError(t, ioutil.WriteFile(src, []byte(download), 0644))
+
+		binDir := filepath.Join(tmp, "bin")
+		require.NoError(t, os.Mkdir(binDir, 0755))
+		dest := filepath.Join(binDir, "mybin")
+		if installed != "" {
+			require.NoError(t, ioutil.WriteFile(dest, []byte(installed), 0755))
+		}
+		return src, dest
+	}
+
+	// assertInstalled checks the installed binary, and that the backup and temporary files were cleaned up
+	assertInstalled := func(t *testing.T, dest string, want string) {
+		got, err := ioutil.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(got))
+
+		fi, err := os.Stat(dest)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), fi.Mode().Perm(), "the binary should be executable")
+
+		entries, err := ioutil.ReadDir(filepath.Dir(dest))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1, "only the binary should be left in the destination directory")
+	}
+
+	t.Run("new install", func(t *testing.T) {
+		src, dest := setup(t, "", newBinary)
+		require.NoError(t, installBinary(src, dest, "--version"))
+		assertInstalled(t, dest, newBinary)
+	})
+
+	t.Run("replace", func(t *testing.T) {
+		src, dest := setup(t, oldBinary, newBinary)
+
+		// Keep the old binary open, as if it were running
+		running, err := os.Open(dest)
+		require.NoError(t, err)
+		defer running.Close()
+
+		require.NoError(t, installBinary(src, dest, "--version"))
+		assertInstalled(t, dest, newBinary)
+
+		got, err := ioutil.ReadAll(running)
+		require.NoError(t, err)
+		assert.Equal(t, oldBinary, string(got), "the running binary should not be modified in place")
+	})
+
+	t.Run("replace without verification", func(t *testing.T) {
+		src, dest := setup(t, oldBinary, brokenBinary)
+		require.NoError(t, installBinary(src, dest, ""))
+		assertInstalled(t, dest, brokenBinary)
+	})
+
+	t.Run("broken binary restores previous version", func(t *testing.T) {
+		src, dest := setup(t, oldBinary, brokenBinary)
+		err := installBinary(src, dest, "--version")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not verify that "+dest+" works with 'mybin --version'")
+		assertInstalled(t, dest, oldBinary)
+	})
+
+	t.Run("broken binary without previous version", func(t *testing.T) {
+		src, dest := setup(t, "", brokenBinary)
+		err := installBinary(src, dest, "--version")
+		require.Error(t, err)
+		assert.NoFileExists(t, dest)
+
+		entries, err := ioutil.ReadDir(filepath.Dir(dest))
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("copy fails", func(t *testing.T) {
+		src, dest := setup(t, oldBinary, newBinary)
+		require.NoError(t, os.Remove(src))
+		err := installBinary(src, dest, "--version")
+		require.Error(t, err)
+		assertInstalled(t, dest, oldBinary)
+	})
+}
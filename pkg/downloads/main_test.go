@@ -0,0 +1,13 @@
+package downloads_test
+
+import (
+	"testing"
+
+	"github.com/carolynvs/magex/pkg/internal/tooltest"
+)
+
+// TestMain is in the external test package, so that it can use tooltest,
+// which imports this package.
+func TestMain(m *testing.M) {
+	tooltest.Main(m)
+}
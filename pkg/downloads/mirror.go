@@ -0,0 +1,66 @@
+package downloads
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MirrorEnvVar is the environment variable with rules that rewrite download
+// URLs to use a mirror, which is tried before the original URL. Rules are
+// comma separated, in the format PREFIX=REPLACEMENT, where a URL starting with
+// PREFIX has it replaced with REPLACEMENT. For example:
+//
+//	MAGEX_DOWNLOAD_MIRROR=https://github.com/=https://mirror.example.com/github/
+const MirrorEnvVar = "MAGEX_DOWNLOAD_MIRROR"
+
+// mirrorRule rewrites URLs that start with a prefix to use a mirror.
+type mirrorRule struct {
+	Prefix      string
+	Replacement string
+}
+
+// parseMirrorRules parses the rules from MirrorEnvVar.
+func parseMirrorRules(value string) ([]mirrorRule, error) {
+	var rules []mirrorRule
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		i := strings.Index(rule, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid %s rule %q, expected PREFIX=REPLACEMENT", MirrorEnvVar, rule)
+		}
+		rules = append(rules, mirrorRule{Prefix: rule[:i], Replacement: rule[i+1:]})
+	}
+	return rules, nil
+}
+
+// withMirrors returns the URLs to try when downloading a file, where each URL
+// is preceded by its mirrors from MirrorEnvVar.
+func withMirrors(srcs []string) ([]string, error) {
+	rules, err := parseMirrorRules(os.Getenv(MirrorEnvVar))
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make([]string, 0, len(srcs))
+	seen := make(map[string]bool, len(srcs))
+	add := func(src string) {
+		if !seen[src] {
+			seen[src] = true
+			urls = append(urls, src)
+		}
+	}
+	for _, src := range srcs {
+		for _, rule := range rules {
+			if strings.HasPrefix(src, rule.Prefix) {
+				add(rule.Replacement + strings.TrimPrefix(src, rule.Prefix))
+			}
+		}
+		add(src)
+	}
+	return urls, nil
+}
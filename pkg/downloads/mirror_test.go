@@ -0,0 +1,161 @@
+package downloads
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithMirrors(t *testing.T) {
+	defer os.Setenv(MirrorEnvVar, os.Getenv(MirrorEnvVar))
+
+	testcases := []struct {
+		name    string
+		rules   string
+		srcs    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "no rules", srcs: []string{"https://a.com/mybin"}, want: []string{"https://a.com/mybin"}},
+		{name: "rule matches", rules: "https://a.com/=https://mirror.com/a/",
+			srcs: []string{"https://a.com/mybin", "https://b.com/mybin"},
+			want: []string{"https://mirror.com/a/mybin", "https://a.com/mybin", "https://b.com/mybin"}},
+		{name: "multiple rules", rules: "https://a.com/=https://mirror1.com/, https://a.com/=https://mirror2.com/",
+			srcs: []string{"https://a.com/mybin"},
+			want: []string{"https://mirror1.com/mybin", "https://mirror2.com/mybin", "https://a.com/mybin"}},
+		{name: "duplicate", rules: "https://a.com/=https://b.com/",
+			srcs: []string{"https://a.com/mybin", "https://b.com/mybin"},
+			want: []string{"https://b.com/mybin", "https://a.com/mybin"}},
+		{name: "invalid rule", rules: "https://a.com/", srcs: []string{"https://a.com/mybin"},
+			wantErr: `invalid MAGEX_DOWNLOAD_MIRROR rule "https://a.com/"`},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv(MirrorEnvVar, tc.rules)
+			got, err := withMirrors(tc.srcs)
+			if tc.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDownload_Mirrors(t *testing.T) {
+	defer os.Setenv(MirrorEnvVar, os.Getenv(MirrorEnvVar))
+	os.Unsetenv(MirrorEnvVar)
+
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer missing.Close()
+
+	var requests []string
+	var authorized []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.Header.Get("Authorization") != "" {
+			authorized = append(authorized, r.URL.Path)
+		}
+		if filepath.Base(r.URL.Path) == "checksums.txt" {
+			w.Write([]byte(sha256Hex("echo ok") + "  mybin\n"))
+			return
+		}
+		w.Write([]byte("echo ok"))
+	}))
+	defer mirror.Close()
+
+	t.Run("mirror templates", func(t *testing.T) {
+		requests = nil
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate: down.URL + "/mybin",
+			MirrorUrlTemplates: []string{
+				missing.URL + "/mybin",
+				mirror.URL + "/{{.VERSION}}/mybin",
+			},
+			Version: "v1.0.0",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/v1.0.0/mybin"}, requests)
+	})
+
+	t.Run("all mirrors fail", func(t *testing.T) {
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:        down.URL + "/mybin",
+			MirrorUrlTemplates: []string{missing.URL + "/mybin"},
+		})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "could not download mybin from any of the 2 urls tried")
+		assert.Contains(t, err.Error(), down.URL+"/mybin: error downloading "+down.URL+"/mybin (503)")
+		assert.Contains(t, err.Error(), missing.URL+"/mybin: error downloading "+missing.URL+"/mybin (404)")
+	})
+
+	t.Run("mirror env var", func(t *testing.T) {
+		defer os.Unsetenv(MirrorEnvVar)
+		os.Setenv(MirrorEnvVar, down.URL+"/="+mirror.URL+"/mirrored/")
+
+		requests = nil
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate: down.URL + "/mybin",
+			Checksum:    sha256Hex("echo ok"),
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/mirrored/mybin"}, requests)
+	})
+
+	t.Run("mirrored checksum file", func(t *testing.T) {
+		defer os.Unsetenv(MirrorEnvVar)
+		os.Setenv(MirrorEnvVar, down.URL+"/="+mirror.URL+"/mirrored/")
+
+		requests = nil
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:         down.URL + "/mybin",
+			ChecksumUrlTemplate: down.URL + "/mybin.sha256",
+		})
+		require.Error(t, err, "the mirror returns the file instead of a checksum")
+		assert.Contains(t, err.Error(), "invalid checksum file")
+		assert.Equal(t, []string{"/mirrored/mybin.sha256"}, requests)
+	})
+
+	t.Run("mirror env var does not get credentials", func(t *testing.T) {
+		defer os.Unsetenv(MirrorEnvVar)
+		os.Setenv(MirrorEnvVar, down.URL+"/="+mirror.URL+"/mirrored/")
+		os.Setenv("MAGEX_TEST_TOKEN", "secret-token")
+		defer os.Unsetenv("MAGEX_TEST_TOKEN")
+
+		requests, authorized = nil, nil
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:       down.URL + "/mybin",
+			BearerTokenEnvVar: "MAGEX_TEST_TOKEN",
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/mirrored/mybin"}, requests)
+		assert.Empty(t, authorized, "the token should only be sent to the original host")
+	})
+
+	t.Run("checksum file from mirror templates", func(t *testing.T) {
+		requests = nil
+		_, err := testDownload(t, nil, DownloadOptions{
+			UrlTemplate:         down.URL + "/{{.VERSION}}/mybin",
+			ChecksumUrlTemplate: down.URL + "/{{.VERSION}}/checksums.txt",
+			MirrorUrlTemplates:  []string{mirror.URL + "/releases/{{.VERSION}}/mybin"},
+			Version:             "v1.0.0",
+		})
+		require.NoError(t, err, "the checksum file should be downloaded from the mirror when the upstream is down")
+		assert.Equal(t, []string{"/releases/v1.0.0/checksums.txt", "/releases/v1.0.0/mybin"}, requests)
+	})
+}
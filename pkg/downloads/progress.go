@@ -0,0 +1,268 @@
+package downloads
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultProgressInterval is how often PeriodicProgress prints the progress
+// of a download.
+const DefaultProgressInterval = 10 * time.Second
+
+// Progress is the state of a download.
+type Progress struct {
+	// URL of the file being downloaded, with any password redacted.
+	URL string
+
+	// Downloaded is the number of bytes downloaded so far.
+	Downloaded int64
+
+	// Total is the size of the file in bytes, or -1 when it is unknown.
+	Total int64
+
+	// Rate is the download speed in bytes per second.
+	Rate float64
+
+	// Elapsed is how long the file has been downloading.
+	Elapsed time.Duration
+}
+
+// Percent returns how much of the file has been downloaded, from 0 to 100, or
+// -1 when the size of the file is unknown.
+func (p Progress) Percent() int {
+	if p.Total <= 0 {
+		return -1
+	}
+	return int(p.Downloaded * 100 / p.Total)
+}
+
+// ProgressReporter displays the progress of a download.
+type ProgressReporter interface {
+	// Start is called when the server starts sending the file.
+	Start(p Progress)
+
+	// Update is called periodically while the file is downloaded.
+	Update(p Progress)
+
+	// Finish is called after the download completes, or fails with the
+	// specified error.
+	Finish(p Progress, err error)
+}
+
+// DefaultProgressReporter returns the reporter used when
+// DownloadOptions.Progress is not set: a progress bar when stderr is a
+// terminal, otherwise PeriodicProgress, which is easier to read in CI logs.
+func DefaultProgressReporter() ProgressReporter {
+	if isTerminal(os.Stderr) {
+		return &TerminalProgress{}
+	}
+	return &PeriodicProgress{}
+}
+
+// isTerminal determines if the file is a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// TerminalProgress displays a progress bar that is redrawn in place.
+type TerminalProgress struct {
+	// Out is where the progress bar is written. Optional, defaults to stderr.
+	Out io.Writer
+
+	drawn bool
+}
+
+// Start draws the progress bar.
+func (r *TerminalProgress) Start(p Progress) {
+	r.draw(p)
+}
+
+// Update redraws the progress bar.
+func (r *TerminalProgress) Update(p Progress) {
+	r.draw(p)
+}
+
+// Finish draws the completed progress bar, and moves to the next line.
+func (r *TerminalProgress) Finish(p Progress, err error) {
+	if !r.drawn {
+		return
+	}
+	if err == nil {
+		r.draw(p)
+	}
+	fmt.Fprintln(r.out())
+	r.drawn = false
+}
+
+func (r *TerminalProgress) draw(p Progress) {
+	const width = 30
+
+	var bar string
+	if percent := p.Percent(); percent >= 0 {
+		filled := width * percent / 100
+		bar = fmt.Sprintf("[%s%s] %3d%% %s/%s", strings.Repeat("=", filled), strings.Repeat(" ", width-filled),
+			percent, formatBytes(p.Downloaded), formatBytes(p.Total))
+	} else {
+		bar = formatBytes(p.Downloaded)
+	}
+
+	// Return to the start of the line and clear it, before redrawing the bar
+	fmt.Fprintf(r.out(), "\r\x1b[K%s %s/s", bar, formatBytes(int64(p.Rate)))
+	r.drawn = true
+}
+
+func (r *TerminalProgress) out() io.Writer {
+	if r.Out == nil {
+		return os.Stderr
+	}
+	return r.Out
+}
+
+// PeriodicProgress prints the progress of a download on a new line at a
+// fixed interval, which works well for logs that are not displayed in a
+// terminal, such as in CI.
+type PeriodicProgress struct {
+	// Out is where the progress is written. Optional, defaults to stderr.
+	Out io.Writer
+
+	// Interval is how often to print the progress. Optional, defaults to
+	// DefaultProgressInterval.
+	Interval time.Duration
+
+	lastPrinted time.Duration
+	printed     bool
+}
+
+// Start resets the reporter for a new download.
+func (r *PeriodicProgress) Start(p Progress) {
+	r.lastPrinted = p.Elapsed
+	r.printed = false
+}
+
+// Update prints the progress when Interval has passed since it was last printed.
+func (r *PeriodicProgress) Update(p Progress) {
+	interval := r.Interval
+	if interval <= 0 {
+		interval = DefaultProgressInterval
+	}
+	if p.Elapsed-r.lastPrinted < interval {
+		return
+	}
+	r.print(p)
+}
+
+// Finish prints the final progress of downloads that took long enough to
+// print their progress.
+func (r *PeriodicProgress) Finish(p Progress, err error) {
+	// Quick downloads do not need a summary, the "Downloading" message is enough
+	if r.printed && err == nil {
+		r.print(p)
+	}
+}
+
+func (r *PeriodicProgress) print(p Progress) {
+	out := r.Out
+	if out == nil {
+		out = os.Stderr
+	}
+
+	if percent := p.Percent(); percent >= 0 {
+		fmt.Fprintf(out, "Downloaded %s of %s (%d%%) at %s/s\n", formatBytes(p.Downloaded), formatBytes(p.Total), percent, formatBytes(int64(p.Rate)))
+	} else {
+		fmt.Fprintf(out, "Downloaded %s at %s/s\n", formatBytes(p.Downloaded), formatBytes(int64(p.Rate)))
+	}
+	r.lastPrinted = p.Elapsed
+	r.printed = true
+}
+
+// formatBytes formats a number of bytes for display, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressTracker reports the progress of downloading a file to a
+// ProgressReporter, across attempts.
+type progressTracker struct {
+	reporter ProgressReporter
+	progress Progress
+	started  time.Time
+
+	// attemptStart and attemptOffset measure the download rate of the current
+	// attempt, so that resuming a download does not inflate it.
+	attemptStart  time.Time
+	attemptOffset int64
+	lastUpdate    time.Time
+}
+
+// progressUpdateInterval limits how often the reporter is updated.
+const progressUpdateInterval = 200 * time.Millisecond
+
+func newProgressTracker(reporter ProgressReporter, src string) *progressTracker {
+	return &progressTracker{
+		reporter: reporter,
+		progress: Progress{URL: redactURL(src), Total: -1},
+	}
+}
+
+// begin is called when the server starts sending the file, at the
+// specified offset when the download is resumed.
+func (t *progressTracker) begin(offset int64, total int64) {
+	if t.reporter == nil {
+		return
+	}
+
+	now := time.Now()
+	t.progress.Downloaded = offset
+	t.progress.Total = total
+	t.attemptStart = now
+	t.attemptOffset = offset
+	if t.started.IsZero() {
+		t.started = now
+		t.reporter.Start(t.progress)
+	}
+}
+
+// Write records the bytes written to the downloaded file.
+func (t *progressTracker) Write(b []byte) (int, error) {
+	if t.reporter == nil {
+		return len(b), nil
+	}
+
+	t.progress.Downloaded += int64(len(b))
+	now := time.Now()
+	if now.Sub(t.lastUpdate) >= progressUpdateInterval {
+		t.lastUpdate = now
+		t.reporter.Update(t.snapshot(now))
+	}
+	return len(b), nil
+}
+
+// finish is called when the download completes or fails.
+func (t *progressTracker) finish(err error) {
+	if t.reporter == nil || t.started.IsZero() {
+		return
+	}
+	t.reporter.Finish(t.snapshot(time.Now()), err)
+}
+
+func (t *progressTracker) snapshot(now time.Time) Progress {
+	p := t.progress
+	p.Elapsed = now.Sub(t.started)
+	if elapsed := now.Sub(t.attemptStart).Seconds(); elapsed > 0 {
+		p.Rate = float64(p.Downloaded-t.attemptOffset) / elapsed
+	}
+	return p
+}
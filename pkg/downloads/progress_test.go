@@ -0,0 +1,170 @@
+package downloads
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ ProgressReporter = &TerminalProgress{}
+var _ ProgressReporter = &PeriodicProgress{}
+
+// recordingProgress records the progress reported for a download.
+type recordingProgress struct {
+	starts  []Progress
+	updates []Progress
+	finish  *Progress
+	err     error
+}
+
+func (r *recordingProgress) Start(p Progress)  { r.starts = append(r.starts, p) }
+func (r *recordingProgress) Update(p Progress) { r.updates = append(r.updates, p) }
+func (r *recordingProgress) Finish(p Progress, err error) {
+	r.finish = &p
+	r.err = err
+}
+
+func TestDownload_Progress(t *testing.T) {
+	contents := strings.Repeat("a", 1<<20)
+
+	t.Run("completed", func(t *testing.T) {
+		progress := &recordingProgress{}
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "mybin", time.Time{}, strings.NewReader(contents))
+		}, DownloadOptions{Progress: progress})
+		require.NoError(t, err)
+
+		require.Len(t, progress.starts, 1)
+		assert.Equal(t, int64(len(contents)), progress.starts[0].Total)
+		assert.Contains(t, progress.starts[0].URL, "/mybin")
+		require.NotNil(t, progress.finish)
+		assert.NoError(t, progress.err)
+		assert.Equal(t, int64(len(contents)), progress.finish.Downloaded)
+		assert.Equal(t, 100, progress.finish.Percent())
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		progress := &recordingProgress{}
+		var count int
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			count++
+			if count == 1 {
+				w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+				w.Write([]byte(contents[:len(contents)/2]))
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+			http.ServeContent(w, r, "mybin", time.Time{}, strings.NewReader(contents))
+		}, DownloadOptions{Progress: progress})
+		require.NoError(t, err)
+
+		require.Len(t, progress.starts, 1, "a resumed download should be reported as the same download")
+		require.NotNil(t, progress.finish)
+		assert.Equal(t, int64(len(contents)), progress.finish.Downloaded)
+		assert.Equal(t, int64(len(contents)), progress.finish.Total)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		progress := &recordingProgress{}
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.Itoa(len(contents)))
+			w.Write([]byte(contents[:len(contents)/2]))
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}, DownloadOptions{Progress: progress, Retries: -1})
+		require.Error(t, err)
+
+		require.NotNil(t, progress.finish)
+		assert.Error(t, progress.err)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		progress := &recordingProgress{}
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, DownloadOptions{Progress: progress})
+		require.Error(t, err)
+		assert.Empty(t, progress.starts, "progress should not be reported when the file was not sent")
+		assert.Nil(t, progress.finish)
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		progress := &recordingProgress{}
+		_, err := testDownload(t, func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(contents))
+		}, DownloadOptions{Progress: progress, NoProgress: true})
+		require.NoError(t, err)
+		assert.Empty(t, progress.starts)
+	})
+}
+
+func TestTerminalProgress(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := &TerminalProgress{Out: out}
+
+	r.Start(Progress{Total: 2048})
+	r.Update(Progress{Downloaded: 1024, Total: 2048, Rate: 512})
+	r.Finish(Progress{Downloaded: 2048, Total: 2048, Rate: 1024}, nil)
+
+	lines := strings.Split(out.String(), "\r\x1b[K")
+	require.Len(t, lines, 4)
+	assert.Equal(t, "[                              ]   0% 0 B/2.0 KiB 0 B/s", lines[1])
+	assert.Equal(t, "[===============               ]  50% 1.0 KiB/2.0 KiB 512 B/s", lines[2])
+	assert.Equal(t, "[==============================] 100% 2.0 KiB/2.0 KiB 1.0 KiB/s\n", lines[3])
+
+	t.Run("unknown size", func(t *testing.T) {
+		out.Reset()
+		r.Start(Progress{Total: -1})
+		r.Update(Progress{Downloaded: 1536, Total: -1, Rate: 1536})
+		r.Finish(Progress{Downloaded: 1536, Total: -1}, errors.New("connection reset"))
+		assert.Equal(t, "\r\x1b[K0 B 0 B/s\r\x1b[K1.5 KiB 1.5 KiB/s\n", out.String())
+	})
+}
+
+func TestPeriodicProgress(t *testing.T) {
+	out := &bytes.Buffer{}
+	r := &PeriodicProgress{Out: out, Interval: time.Second}
+
+	r.Start(Progress{Total: 2048})
+	r.Update(Progress{Downloaded: 512, Total: 2048, Rate: 512, Elapsed: 500 * time.Millisecond})
+	r.Update(Progress{Downloaded: 1024, Total: 2048, Rate: 512, Elapsed: 1100 * time.Millisecond})
+	r.Update(Progress{Downloaded: 1536, Total: 2048, Rate: 512, Elapsed: 1500 * time.Millisecond})
+	r.Finish(Progress{Downloaded: 2048, Total: 2048, Rate: 1024, Elapsed: 2 * time.Second}, nil)
+
+	assert.Equal(t, "Downloaded 1.0 KiB of 2.0 KiB (50%) at 512 B/s\nDownloaded 2.0 KiB of 2.0 KiB (100%) at 1.0 KiB/s\n", out.String())
+
+	t.Run("quick download", func(t *testing.T) {
+		out.Reset()
+		r.Start(Progress{Total: -1})
+		r.Update(Progress{Downloaded: 1024, Total: -1, Rate: 2048, Elapsed: 500 * time.Millisecond})
+		r.Finish(Progress{Downloaded: 2048, Total: -1, Rate: 2048, Elapsed: time.Second - time.Millisecond}, nil)
+		assert.Empty(t, out.String())
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0 B", formatBytes(0))
+	assert.Equal(t, "1023 B", formatBytes(1023))
+	assert.Equal(t, "1.0 KiB", formatBytes(1024))
+	assert.Equal(t, "1.5 MiB", formatBytes(3<<19))
+	assert.Equal(t, "2.0 GiB", formatBytes(2<<30))
+}
+
+func TestDefaultProgressReporter(t *testing.T) {
+	f, err := ioutil.TempFile("", "magex")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	assert.False(t, isTerminal(f), "a regular file is not a terminal")
+	assert.NotNil(t, DefaultProgressReporter())
+}
@@ -0,0 +1,133 @@
+package downloads
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// templateData is the data available to the templates in DownloadOptions.
+type templateData struct {
+	// GOOS is runtime.GOOS, or its replacement from DownloadOptions.OsReplacement.
+	GOOS string
+
+	// GOOS_TITLE is runtime.GOOS title-cased, e.g. Linux or Darwin.
+	GOOS_TITLE string
+
+	// GOARCH is runtime.GOARCH, or its replacement from DownloadOptions.ArchReplacement.
+	GOARCH string
+
+	// UNAME_ARCH is the architecture as reported by uname -m, e.g. x86_64 or aarch64.
+	UNAME_ARCH string
+
+	// GOAMD64 is the amd64 microarchitecture level, e.g. v1 or v3, from the
+	// GOAMD64 environment variable, defaulting to v1. Empty on other architectures.
+	GOAMD64 string
+
+	// GOARM is the ARM version, e.g. 6 or 7, from the GOARM environment
+	// variable, defaulting to 7. Empty on other architectures.
+	GOARM string
+
+	// LIBC is the C standard library used on Linux, musl or gnu. Empty on other
+	// operating systems.
+	LIBC string
+
+	// EXT is DownloadOptions.Ext.
+	EXT string
+
+	// VERSION is DownloadOptions.Version.
+	VERSION string
+
+	// VERSION_NUMBER is the version without the v prefix, e.g. 1.2.3.
+	VERSION_NUMBER string
+}
+
+// templateFuncs are the functions available to the templates in DownloadOptions.
+var templateFuncs = template.FuncMap{
+	// trimPrefix removes a prefix, e.g. {{.VERSION | trimPrefix "v"}}
+	"trimPrefix": func(prefix string, s string) string { return strings.TrimPrefix(s, prefix) },
+	// title upper cases the first letter of each word, e.g. {{title .GOOS}}
+	"title": title,
+	// lower converts to lower case, e.g. {{lower .GOOS}}
+	"lower": strings.ToLower,
+}
+
+// detectLibc returns the C standard library used on Linux. Tests replace it
+// to simulate other systems.
+var detectLibc = func() string {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if musl, _ := filepath.Glob("/lib/ld-musl-*.so.1"); len(musl) > 0 {
+		return "musl"
+	}
+	return "gnu"
+}
+
+func newTemplateData(opts DownloadOptions) templateData {
+	data := templateData{
+		GOOS:           runtime.GOOS,
+		GOOS_TITLE:     title(runtime.GOOS),
+		GOARCH:         runtime.GOARCH,
+		UNAME_ARCH:     unameArch(runtime.GOARCH),
+		LIBC:           detectLibc(),
+		EXT:            opts.Ext,
+		VERSION:        opts.Version,
+		VERSION_NUMBER: strings.TrimPrefix(opts.Version, "v"),
+	}
+
+	switch runtime.GOARCH {
+	case "amd64":
+		data.GOAMD64 = getenvDefault("GOAMD64", "v1")
+	case "arm":
+		data.GOARM = getenvDefault("GOARM", "7")
+		data.UNAME_ARCH = "armv" + data.GOARM + "l"
+	}
+
+	if overrideGoos, ok := opts.OsReplacement[runtime.GOOS]; ok {
+		data.GOOS = overrideGoos
+	}
+
+	if overrideGoarch, ok := opts.ArchReplacement[runtime.GOARCH]; ok {
+		data.GOARCH = overrideGoarch
+	}
+
+	return data
+}
+
+// unameArch converts a GOARCH to the architecture reported by uname -m.
+func unameArch(goarch string) string {
+	switch goarch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		return "aarch64"
+	case "386":
+		return "i386"
+	default:
+		return goarch
+	}
+}
+
+func getenvDefault(key string, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+// title upper cases the first letter of each word.
+func title(s string) string {
+	prev := ' '
+	return strings.Map(func(r rune) rune {
+		isStart := unicode.IsSpace(prev) || prev == '-' || prev == '_'
+		prev = r
+		if isStart {
+			return unicode.ToUpper(r)
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,96 @@
+package downloads
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	origDetectLibc := detectLibc
+	defer func() { detectLibc = origDetectLibc }()
+	detectLibc = func() string { return "musl" }
+
+	defer os.Setenv("GOAMD64", os.Getenv("GOAMD64"))
+	defer os.Setenv("GOARM", os.Getenv("GOARM"))
+	os.Unsetenv("GOAMD64")
+	os.Unsetenv("GOARM")
+
+	opts := DownloadOptions{
+		Name:    "mybin",
+		Version: "v1.2.3",
+		Ext:     ".tar.gz",
+	}
+
+	testcases := []struct {
+		name string
+		tmpl string
+		want string
+	}{
+		{name: "existing variables", tmpl: "{{.VERSION}}/mybin-{{.GOOS}}-{{.GOARCH}}{{.EXT}}",
+			want: "v1.2.3/mybin-" + runtime.GOOS + "-" + runtime.GOARCH + ".tar.gz"},
+		{name: "version number", tmpl: "{{.VERSION_NUMBER}}", want: "1.2.3"},
+		{name: "trimPrefix", tmpl: `{{.VERSION | trimPrefix "v"}}`, want: "1.2.3"},
+		{name: "title", tmpl: `{{title "darwin"}}`, want: "Darwin"},
+		{name: "lower", tmpl: `{{lower "Linux"}}`, want: "linux"},
+		{name: "title os", tmpl: "{{.GOOS_TITLE}}", want: title(runtime.GOOS)},
+		{name: "libc", tmpl: "{{.UNAME_ARCH}}-unknown-linux-{{.LIBC}}", want: unameArch(runtime.GOARCH) + "-unknown-linux-musl"},
+	}
+	if runtime.GOARCH == "amd64" {
+		testcases = append(testcases,
+			struct{ name, tmpl, want string }{name: "uname arch", tmpl: "{{.UNAME_ARCH}}", want: "x86_64"},
+			struct{ name, tmpl, want string }{name: "amd64 level", tmpl: "{{.GOARCH}}{{.GOAMD64}}", want: "amd64v1"},
+		)
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := RenderTemplate(tc.tmpl, opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("replacements", func(t *testing.T) {
+		opts := opts
+		opts.OsReplacement = map[string]string{runtime.GOOS: "myos"}
+		opts.ArchReplacement = map[string]string{runtime.GOARCH: "myarch"}
+		got, err := RenderTemplate("{{.GOOS}}-{{.GOARCH}}-{{.GOOS_TITLE}}-{{.UNAME_ARCH}}", opts)
+		require.NoError(t, err)
+		assert.Equal(t, "myos-myarch-"+title(runtime.GOOS)+"-"+unameArch(runtime.GOARCH), got,
+			"replacements should only apply to GOOS and GOARCH")
+	})
+
+	t.Run("GOAMD64", func(t *testing.T) {
+		if runtime.GOARCH != "amd64" {
+			t.Skip("GOAMD64 is only set on amd64")
+		}
+		os.Setenv("GOAMD64", "v3")
+		defer os.Unsetenv("GOAMD64")
+		got, err := RenderTemplate("{{.GOARCH}}{{.GOAMD64}}", opts)
+		require.NoError(t, err)
+		assert.Equal(t, "amd64v3", got)
+	})
+
+	t.Run("invalid template", func(t *testing.T) {
+		_, err := RenderTemplate("{{.VERSION", opts)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error parsing {{.VERSION as a Go template")
+	})
+}
+
+func TestUnameArch(t *testing.T) {
+	assert.Equal(t, "x86_64", unameArch("amd64"))
+	assert.Equal(t, "aarch64", unameArch("arm64"))
+	assert.Equal(t, "i386", unameArch("386"))
+	assert.Equal(t, "s390x", unameArch("s390x"))
+}
+
+func TestTitle(t *testing.T) {
+	assert.Equal(t, "Linux", title("linux"))
+	assert.Equal(t, "Linux-Musl", title("linux-musl"))
+	assert.Equal(t, "", title(""))
+}
@@ -3,6 +3,7 @@ package pkg
 import (
 	"fmt"
 	"io/ioutil"
+	"log"
 	"os"
 	"os/exec"
 	"path"
@@ -16,6 +17,7 @@ import (
 	"github.com/carolynvs/magex/pkg/downloads"
 	"github.com/carolynvs/magex/shx"
 	"github.com/carolynvs/magex/xplat"
+	"github.com/magefile/mage/mg"
 )
 
 // EnsureMage checks if mage is installed, and installs it if needed.
@@ -290,12 +292,10 @@ func GetCommandVersion(cmd string, versionCmd string) (string, error) {
 	return matches[0], nil
 }
 
-// DownloadToGopathBin downloads an executable file to GOPATH/bin.
-// src can include the following template values:
-//   - {{.GOOS}}
-//   - {{.GOARCH}}
-//   - {{.EXT}}
-//   - {{.VERSION}}
+// DownloadToGopathBin downloads an executable file to GOPATH/bin. Use
+// DownloadToDir to download to another directory, such as ./bin.
+// src is rendered with downloads.RenderTemplate, which lists the available
+// template variables and functions.
 func DownloadToGopathBin(srcTemplate string, name string, version string) error {
 	opts := downloads.DownloadOptions{
 		UrlTemplate: srcTemplate,
@@ -305,3 +305,80 @@ func DownloadToGopathBin(srcTemplate string, name string, version string) error
 	}
 	return downloads.DownloadToGopathBin(opts)
 }
+
+// DownloadToDir downloads an executable file to the destination directory,
+// such as ./bin, creating the directory if needed and adding it to PATH.
+// src is rendered with downloads.RenderTemplate, which lists the available
+// template variables and functions.
+func DownloadToDir(destDir string, srcTemplate string, name string, version string) error {
+	opts := downloads.DownloadOptions{
+		UrlTemplate: srcTemplate,
+		Name:        name,
+		Version:     version,
+		Ext:         xplat.FileExt(),
+	}
+	return downloads.DownloadToDir(destDir, opts)
+}
+
+// EnsureDownloadOptions are the set of options that can be passed to EnsureDownload.
+type EnsureDownloadOptions struct {
+	downloads.DownloadOptions
+
+	// AllowedVersion is a semver range that specifies which versions are acceptable
+	// if found. For example, ^1.2.3 or 2.x. When unspecified, Version is used as the
+	// minimum version and sets the allowed major version. For example, a Version of
+	// 1.2.3 would result in an AllowedVersion of ^1.2.3. When neither is a semver
+	// value, any installed version is acceptable.
+	AllowedVersion string
+
+	// VersionCommand is the arguments to pass to the CLI to determine the installed version.
+	// For example, "version" or "--version". When unspecified the CLI is called without any arguments.
+	VersionCommand string
+
+	// Destination is the location where the CLI should be installed, which is
+	// added to PATH. Defaults to GOPATH/bin. Using ./bin is recommended to
+	// require build tools without modifying the host environment.
+	Destination string
+}
+
+// EnsureDownload checks if the command is installed with an allowed version,
+// and if it is missing, the installed version is not allowed, or the version
+// cannot be determined, downloads the specified version to the destination.
+func EnsureDownload(opts EnsureDownloadOptions) error {
+	// Default the constraint to [Version - next major)
+	if opts.AllowedVersion == "" {
+		opts.AllowedVersion = makeDefaultVersionConstraint(opts.Version)
+	}
+
+	// Add the destination to PATH first, so that the CLI is found there
+	if opts.Destination != "" {
+		dest, err := downloads.EnsureBinDir(opts.Destination)
+		if err != nil {
+			return err
+		}
+		opts.Destination = dest
+	}
+
+	found, err := IsCommandAvailable(opts.Name, opts.VersionCommand, opts.AllowedVersion)
+	if err != nil {
+		// Replace a broken installation, such as a binary for another platform
+		if mg.Verbose() {
+			log.Printf("could not determine the installed version of %s, downloading it again: %s\n", opts.Name, err)
+		}
+		found = false
+	}
+
+	if !found {
+		if opts.Ext == "" {
+			opts.Ext = xplat.FileExt()
+		}
+		if opts.VerifyCommand == "" {
+			opts.VerifyCommand = opts.VersionCommand
+		}
+		if opts.Destination != "" {
+			return downloads.Download(opts.Destination, opts.DownloadOptions)
+		}
+		return downloads.DownloadToGopathBin(opts.DownloadOptions)
+	}
+	return nil
+}
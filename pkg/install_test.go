@@ -7,12 +7,17 @@ import (
 	"testing"
 
 	"github.com/carolynvs/magex/pkg/gopath"
+	"github.com/carolynvs/magex/pkg/internal/tooltest"
 	"github.com/carolynvs/magex/xplat"
 	"github.com/magefile/mage/mg"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+func TestMain(m *testing.M) {
+	tooltest.Main(m)
+}
+
 func TestDownloadToGopathBin(t *testing.T) {
 	err, cleanup := gopath.UseTempGopath()
 	require.NoError(t, err, "Failed to set up a temporary GOPATH")
@@ -157,3 +162,103 @@ func TestEnsurePackage_Upgrade(t *testing.T) {
 		})
 	}
 }
+
+func TestEnsureDownload(t *testing.T) {
+	testcases := []struct {
+		name              string
+		installedVersion  string
+		version           string
+		versionConstraint string
+		wantDownload      bool
+		wantVersion       string
+	}{
+		{name: "not installed", version: "v1.2.3", wantDownload: true, wantVersion: "v1.2.3"},
+		{name: "installed version allowed", installedVersion: "v1.2.0", version: "v1.2.3", versionConstraint: "1.x", wantVersion: "v1.2.0"},
+		{name: "installed version not allowed", installedVersion: "v1.2.0", version: "v1.2.3", versionConstraint: "^1.2.3", wantDownload: true, wantVersion: "v1.2.3"},
+		{name: "default constraint allows installed version", installedVersion: "v1.3.0", version: "v1.2.3", wantVersion: "v1.3.0"},
+		{name: "default constraint requires higher version", installedVersion: "v1.2.0", version: "v1.2.3", wantDownload: true, wantVersion: "v1.2.3"},
+		{name: "default constraint requires same major version", installedVersion: "v2.0.0", version: "v1.2.3", wantDownload: true, wantVersion: "v1.2.3"},
+		{name: "installed version fails", installedVersion: "broken", version: "v1.2.3", wantDownload: true, wantVersion: "v1.2.3"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			svr, downloadCount := tooltest.NewServer(t)
+
+			err, cleanup := gopath.UseTempGopath()
+			require.NoError(t, err, "Failed to set up a temporary GOPATH")
+			defer cleanup()
+
+			opts := EnsureDownloadOptions{
+				DownloadOptions: tooltest.DownloadOptions(svr.URL, tc.version),
+				AllowedVersion:  tc.versionConstraint,
+				VersionCommand:  "--version",
+			}
+
+			if tc.installedVersion != "" {
+				installed := opts.DownloadOptions
+				installed.Version = tc.installedVersion
+				require.NoError(t, DownloadToGopathBin(installed.UrlTemplate, installed.Name, installed.Version))
+				*downloadCount = 0
+			}
+
+			err = EnsureDownload(opts)
+			require.NoError(t, err)
+			assert.Equal(t, tc.wantDownload, *downloadCount > 0, "unexpected download")
+
+			installedVersion, err := GetCommandVersion("mytool", "--version")
+			require.NoError(t, err, "GetCommandVersion failed")
+			assert.Equal(t, tc.wantVersion, installedVersion, "incorrect version was installed")
+		})
+	}
+}
+
+func TestEnsureDownload_IntoDirectory(t *testing.T) {
+	svr, downloadCount := tooltest.NewServer(t)
+
+	err, cleanup := gopath.UseTempGopath()
+	require.NoError(t, err, "Failed to set up a temporary GOPATH")
+	defer cleanup()
+
+	tmpBin, err := os.MkdirTemp("", "magex")
+	require.NoError(t, err, "Failed to create temporary bin directory")
+	defer os.RemoveAll(tmpBin)
+
+	opts := EnsureDownloadOptions{
+		DownloadOptions: tooltest.DownloadOptions(svr.URL, "v1.2.3"),
+		VersionCommand:  "--version",
+		Destination:     filepath.Join(tmpBin, "bin"),
+	}
+	err = EnsureDownload(opts)
+	require.NoError(t, err)
+
+	cmdPath := filepath.Join(tmpBin, "bin", "mytool"+xplat.FileExt())
+	require.FileExists(t, cmdPath, "The command was not installed into the bin directory")
+	assert.NoFileExists(t, filepath.Join(gopath.GetGopathBin(), "mytool"+xplat.FileExt()))
+	assert.True(t, xplat.InPath(filepath.Join(tmpBin, "bin")), "The bin directory was not added to PATH")
+
+	// The command installed in the destination is found
+	err = EnsureDownload(opts)
+	require.NoError(t, err)
+	assert.Equal(t, 1, *downloadCount, "the command should not be downloaded again")
+}
+
+func TestDownloadToDir(t *testing.T) {
+	svr, _ := tooltest.NewServer(t)
+
+	err, cleanup := gopath.UseTempGopath()
+	require.NoError(t, err, "Failed to set up a temporary GOPATH")
+	defer cleanup()
+
+	tmpBin, err := os.MkdirTemp("", "magex")
+	require.NoError(t, err, "Failed to create temporary bin directory")
+	defer os.RemoveAll(tmpBin)
+
+	err = DownloadToDir(tmpBin, svr.URL+"/{{.VERSION}}/mytool", "mytool", "v1.2.3")
+	require.NoError(t, err)
+
+	installedVersion, err := GetCommandVersion("mytool", "--version")
+	require.NoError(t, err, "GetCommandVersion failed")
+	assert.Equal(t, "v1.2.3", installedVersion)
+	assert.FileExists(t, filepath.Join(tmpBin, "mytool"+xplat.FileExt()))
+}
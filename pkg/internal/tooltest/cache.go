@@ -0,0 +1,23 @@
+package tooltest
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/carolynvs/magex/pkg/downloads"
+)
+
+// Main runs the tests with the download cache in a temporary directory, so
+// that the user's download cache is not used. Call it from TestMain.
+func Main(m *testing.M) {
+	tmp, err := ioutil.TempDir("", "magex-cache")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv(downloads.CacheEnvVar, tmp)
+
+	code := m.Run()
+	os.RemoveAll(tmp)
+	os.Exit(code)
+}
@@ -0,0 +1,95 @@
+// Package tooltest serves a fake command-line tool, mytool, for testing
+// downloads and installs.
+package tooltest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/carolynvs/magex/pkg/downloads"
+	"github.com/mholt/archiver/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// NewServer serves a fake tool that prints the version in the requested path,
+// e.g. /v1.2.3/mytool, or fails for /broken/mytool, and counts the number of
+// downloads. The tool is served in an archive when the path has an archive
+// extension, e.g. /v1.2.3/mytool.tar.gz.
+func NewServer(t *testing.T) (*httptest.Server, *int) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake tool is a shell script")
+	}
+
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	var mu sync.Mutex
+	var downloadCount int
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		downloadCount++
+		version := path.Base(path.Dir(r.URL.Path))
+		script := fmt.Sprintf("#!/bin/sh\necho mytool version %s\n", version)
+		if version == "broken" {
+			script = "#!/bin/sh\nexit 1\n"
+		}
+
+		name := path.Base(r.URL.Path)
+		if !strings.HasPrefix(name, "mytool.") {
+			fmt.Fprint(w, script)
+			return
+		}
+
+		// The handler doesn't run on the test goroutine, so it cannot stop the test
+		archive, err := buildArchive(filepath.Join(tmp, version), name, script)
+		if err != nil {
+			t.Errorf("could not build %s: %s", name, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, archive)
+	}))
+	t.Cleanup(svr.Close)
+	return svr, &downloadCount
+}
+
+// buildArchive creates an archive of the tool in the directory, unless it
+// already exists, and returns its path.
+func buildArchive(dir string, name string, script string) (string, error) {
+	archive := filepath.Join(dir, name)
+	if _, err := os.Stat(archive); err == nil {
+		return archive, nil
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tool := filepath.Join(dir, "mytool")
+	if err := ioutil.WriteFile(tool, []byte(script), 0755); err != nil {
+		return "", err
+	}
+	return archive, archiver.Archive([]string{tool}, archive)
+}
+
+// DownloadOptions returns the options to download a version of the fake tool
+// from the server.
+func DownloadOptions(url string, version string) downloads.DownloadOptions {
+	return downloads.DownloadOptions{
+		UrlTemplate: url + "/{{.VERSION}}/mytool{{.EXT}}",
+		Name:        "mytool",
+		Version:     version,
+		NoCache:     true,
+	}
+}
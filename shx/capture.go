@@ -2,55 +2,287 @@ package shx
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"sync"
+
+	"github.com/carolynvs/magex/mgx"
 )
 
-type capture struct {
-	f        *os.File
-	releaseF os.File
-	w        *os.File
-	out      chan string
+// RecordOptions are the set of options that can be passed to RecordWith.
+type RecordOptions struct {
+	// Stdout records what is written to os.Stdout.
+	Stdout bool
+
+	// Stderr records what is written to os.Stderr, including the output of
+	// the log package when it writes to os.Stderr.
+	Stderr bool
+
+	// Tee also writes the recorded output to the stream that was redirected,
+	// e.g. the terminal or an outer recording. When recording both streams,
+	// the combined output is written to the redirected stdout.
+	Tee bool
+}
+
+// Recording captures what is written to os.Stdout and os.Stderr.
+//
+// Recordings may be nested, the most recently started recording receives the
+// output until it is released. Recording redirects the file descriptors of
+// os.Stdout and os.Stderr, so output written through references to them that
+// were taken before the recording started, such as the Stdout of a
+// PreparedCommand or a logger, is recorded as well. Other goroutines may keep
+// writing to the streams while a recording is started or released, each
+// write is either recorded or written to the redirected stream. On Windows,
+// the streams are replaced in place instead, which is not synchronized with
+// writes from other goroutines.
+type Recording struct {
+	opts RecordOptions
+	r    *os.File
+	w    *os.File
+	buf  bytes.Buffer
+	done chan struct{}
+	once sync.Once
+
+	// tee is where output is written after the recording is released and
+	// is draining the remaining output.
+	tee io.Writer
 }
 
 // RecordStdout records what is written to os.Stdout.
-func RecordStdout() *capture {
-	return captureFile(os.Stdout)
+// Panics when the recording cannot be started.
+func RecordStdout() *Recording {
+	return mustRecord(RecordOptions{Stdout: true})
 }
 
 // RecordStderr records what is written to os.Stderr.
-func RecordStderr() *capture {
-	return captureFile(os.Stderr)
+// Panics when the recording cannot be started.
+func RecordStderr() *Recording {
+	return mustRecord(RecordOptions{Stderr: true})
+}
+
+// RecordOutput records what is written to both os.Stdout and os.Stderr,
+// combined in the order that it was written.
+// Panics when the recording cannot be started.
+func RecordOutput() *Recording {
+	return mustRecord(RecordOptions{Stdout: true, Stderr: true})
 }
 
-func captureFile(f *os.File) *capture {
-	c := &capture{
-		f:        f,
-		releaseF: *f,
+func mustRecord(opts RecordOptions) *Recording {
+	rec, err := RecordWith(opts)
+	mgx.Must(err)
+	return rec
+}
+
+// RecordWith starts recording os.Stdout and/or os.Stderr with the specified
+// RecordOptions. Call Output or Release to stop recording.
+func RecordWith(opts RecordOptions) (*Recording, error) {
+	if !opts.Stdout && !opts.Stderr {
+		return nil, errors.New("at least one of Stdout or Stderr must be recorded")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not create a pipe to record output: %w", err)
 	}
-	r, w, _ := os.Pipe()
-	c.w = w
-	*f = *w
+	// Fd switches the pipe to blocking mode, so that writes to the redirected
+	// streams wait for the recording to read them, instead of failing when
+	// the pipe is full.
+	w.Fd()
 
-	c.out = make(chan string)
+	rec := &Recording{
+		opts: opts,
+		r:    r,
+		w:    w,
+		done: make(chan struct{}),
+	}
+
+	var dest io.Writer = &rec.buf
+	if opts.Tee {
+		dest = io.MultiWriter(&rec.buf, teeWriter{rec})
+	}
 	go func() {
-		var buf bytes.Buffer
-		io.MultiWriter(f, &buf)
-		io.Copy(&buf, r)
-		c.out <- buf.String()
+		defer close(rec.done)
+		io.Copy(dest, r)
+		r.Close()
 	}()
 
-	return c
+	if err := recordings.push(rec); err != nil {
+		w.Close()
+		<-rec.done
+		return nil, fmt.Errorf("could not redirect the output to the recording: %w", err)
+	}
+	return rec, nil
+}
+
+// Release stops recording and restores the redirected streams. It is safe to
+// call more than once.
+func (r *Recording) Release() {
+	r.once.Do(func() {
+		recordings.remove(r)
+		r.w.Close()
+	})
+}
+
+// Output stops recording and returns what was recorded.
+func (r *Recording) Output() string {
+	r.Release()
+	<-r.done
+	return r.buf.String()
+}
+
+// teeWriter writes to the stream beneath the recording.
+type teeWriter struct {
+	rec *Recording
+}
+
+func (t teeWriter) Write(p []byte) (int, error) {
+	// Ignore errors so that the recording continues when the stream is closed
+	recordings.underlying(t.rec).Write(p)
+	return len(p), nil
+}
+
+// recordings tracks the active recordings for each stream, in the order that
+// they were started.
+var recordings = &recordingStack{}
+
+type recordingStack struct {
+	mu     sync.Mutex
+	stdout []*Recording
+	stderr []*Recording
+
+	// Copies of the streams before any recordings were started
+	origStdout *os.File
+	origStderr *os.File
+}
+
+func (s *recordingStack) push(rec *Recording) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if rec.opts.Stdout && len(s.stdout) == 0 {
+		orig, err := saveStream(os.Stdout)
+		if err != nil {
+			return err
+		}
+		s.origStdout = orig
+	}
+	if rec.opts.Stderr && len(s.stderr) == 0 {
+		orig, err := saveStream(os.Stderr)
+		if err != nil {
+			s.releaseOriginals()
+			return err
+		}
+		s.origStderr = orig
+	}
+
+	if rec.opts.Stdout {
+		s.stdout = append(s.stdout, rec)
+	}
+	if rec.opts.Stderr {
+		s.stderr = append(s.stderr, rec)
+	}
+	if err := s.apply(rec.opts); err != nil {
+		s.stdout = removeRecording(s.stdout, rec)
+		s.stderr = removeRecording(s.stderr, rec)
+		s.apply(rec.opts)
+		s.releaseOriginals()
+		return err
+	}
+	return nil
 }
 
-// Release reverts the changes to the captured output.
-func (c *capture) Release() {
-	*c.f = c.releaseF
-	c.w.Close()
+func (s *recordingStack) remove(rec *Recording) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec.tee = s.beneath(rec)
+	s.stdout = removeRecording(s.stdout, rec)
+	s.stderr = removeRecording(s.stderr, rec)
+	s.apply(rec.opts)
+	s.releaseOriginals()
 }
 
-// Output releases the captured file and returns the output.
-func (c *capture) Output() string {
-	c.Release()
-	return <-c.out
+// releaseOriginals closes the copies of the streams that are no longer
+// redirected.
+func (s *recordingStack) releaseOriginals() {
+	if len(s.stdout) == 0 && s.origStdout != nil {
+		releaseStream(s.origStdout)
+		s.origStdout = nil
+	}
+	if len(s.stderr) == 0 && s.origStderr != nil {
+		releaseStream(s.origStderr)
+		s.origStderr = nil
+	}
+}
+
+// underlying returns the stream that was redirected by the recording.
+func (s *recordingStack) underlying(rec *Recording) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if w := s.beneath(rec); w != nil {
+		return w
+	}
+	if rec.tee != nil {
+		return rec.tee
+	}
+	return ioutil.Discard
+}
+
+// beneath returns the stream that the recording redirected, or nil when the
+// recording isn't active.
+func (s *recordingStack) beneath(rec *Recording) io.Writer {
+	stack, orig := s.stdout, s.origStdout
+	if !rec.opts.Stdout {
+		stack, orig = s.stderr, s.origStderr
+	}
+
+	for i, active := range stack {
+		if active == rec {
+			if i == 0 {
+				return orig
+			}
+			return stack[i-1].w
+		}
+	}
+	return nil
+}
+
+// apply redirects the streams selected by opts to the most recent recording.
+// The streams are redirected in place, instead of assigning new files to the
+// os.Stdout and os.Stderr variables, so that existing references to them are
+// redirected too.
+func (s *recordingStack) apply(opts RecordOptions) error {
+	if opts.Stdout && s.origStdout != nil {
+		stdout := s.origStdout
+		if len(s.stdout) > 0 {
+			stdout = s.stdout[len(s.stdout)-1].w
+		}
+		if err := redirectStream(os.Stdout, stdout); err != nil {
+			return err
+		}
+	}
+
+	if opts.Stderr && s.origStderr != nil {
+		stderr := s.origStderr
+		if len(s.stderr) > 0 {
+			stderr = s.stderr[len(s.stderr)-1].w
+		}
+		if err := redirectStream(os.Stderr, stderr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeRecording(stack []*Recording, rec *Recording) []*Recording {
+	for i, active := range stack {
+		if active == rec {
+			return append(stack[:i:i], stack[i+1:]...)
+		}
+	}
+	return stack
 }
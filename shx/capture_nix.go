@@ -0,0 +1,67 @@
+//go:build !windows
+// +build !windows
+
+package shx
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// saveStream returns a new file for the stream's current destination, so that
+// it can be restored after the stream is redirected.
+func saveStream(f *os.File) (*os.File, error) {
+	fd, ok := rawFd(f)
+	if !ok {
+		return nil, errors.New("could not get the file descriptor")
+	}
+
+	// Hold the fork lock so that the copy is not inherited by commands that are started meanwhile
+	syscall.ForkLock.RLock()
+	defer syscall.ForkLock.RUnlock()
+	saved, err := syscall.Dup(int(fd))
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(saved)
+	return os.NewFile(uintptr(saved), f.Name()), nil
+}
+
+// redirectStream points the stream's file descriptor at the destination. The
+// file descriptor is replaced atomically, instead of changing the *os.File,
+// so that it is safe while other goroutines write to the stream, and every
+// write goes to either the previous or the new destination.
+func redirectStream(f *os.File, dest *os.File) error {
+	fd, ok := rawFd(f)
+	if !ok {
+		return errors.New("could not get the file descriptor")
+	}
+	destFd, ok := rawFd(dest)
+	if !ok {
+		return errors.New("could not get the file descriptor")
+	}
+	if fd == destFd {
+		return nil
+	}
+	return dup2(int(destFd), int(fd))
+}
+
+// releaseStream closes the file returned by saveStream.
+func releaseStream(saved *os.File) {
+	saved.Close()
+}
+
+// rawFd returns the file descriptor of the file without changing it to
+// blocking mode, like Fd does.
+func rawFd(f *os.File) (uintptr, bool) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var fd uintptr
+	err = conn.Control(func(sysFd uintptr) {
+		fd = sysFd
+	})
+	return fd, err == nil
+}
@@ -2,10 +2,15 @@ package shx
 
 import (
 	"fmt"
+	"log"
 	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRecordStderr(t *testing.T) {
@@ -14,9 +19,7 @@ func TestRecordStderr(t *testing.T) {
 
 	stderr := RecordStderr()
 
-	// Verify that stderr has been redirected
 	assert.Equal(t, "/dev/stderr", orig.Name())
-	assert.NotEqual(t, *os.Stderr, orig)
 
 	fmt.Fprint(os.Stderr, msg)
 	got := stderr.Output()
@@ -31,9 +34,7 @@ func TestRecordStdout(t *testing.T) {
 
 	stderr := RecordStdout()
 
-	// Verify that stdout has been redirected
 	assert.Equal(t, "/dev/stdout", orig.Name())
-	assert.NotEqual(t, *os.Stdout, orig)
 
 	fmt.Fprint(os.Stdout, msg)
 	got := stderr.Output()
@@ -41,3 +42,132 @@ func TestRecordStdout(t *testing.T) {
 
 	assert.Equal(t, *os.Stdout, orig, "Stdout was not restored")
 }
+
+func TestRecordOutput(t *testing.T) {
+	rec := RecordOutput()
+	defer rec.Release()
+
+	fmt.Fprint(os.Stdout, "1")
+	fmt.Fprint(os.Stderr, "2")
+	fmt.Fprint(os.Stdout, "3")
+	log.SetFlags(0)
+	defer log.SetFlags(log.LstdFlags)
+	log.Print("4")
+
+	assert.Equal(t, "1234\n", rec.Output(), "stdout and stderr should be combined in order")
+}
+
+func TestRecordOutput_ExistingWriters(t *testing.T) {
+	// Take references to the streams before the recording starts
+	logger := log.New(os.Stderr, "", 0)
+	cmd := Command("go", "run", "echo.go", "command")
+
+	rec := RecordOutput()
+	defer rec.Release()
+
+	logger.Print("logger")
+	require.NoError(t, cmd.RunV())
+
+	assert.Equal(t, "logger\ncommand\n", rec.Output())
+}
+
+func TestRecordWith_Nested(t *testing.T) {
+	orig := os.Stdout
+
+	outer := RecordStdout()
+	defer outer.Release()
+	fmt.Fprint(os.Stdout, "outer1 ")
+
+	inner := RecordStdout()
+	defer inner.Release()
+	fmt.Fprint(os.Stdout, "inner")
+	assert.Equal(t, "inner", inner.Output())
+
+	fmt.Fprint(os.Stdout, "outer2")
+	assert.Equal(t, "outer1 outer2", outer.Output())
+
+	assert.Same(t, orig, os.Stdout, "Stdout was not restored")
+}
+
+func TestRecordWith_Overlapping(t *testing.T) {
+	orig := os.Stdout
+
+	first := RecordStdout()
+	defer first.Release()
+	second := RecordStdout()
+	defer second.Release()
+
+	// Releasing the first recording should not stop the second
+	assert.Equal(t, "", first.Output())
+	fmt.Fprint(os.Stdout, "second")
+	assert.Equal(t, "second", second.Output())
+
+	assert.Same(t, orig, os.Stdout, "Stdout was not restored")
+}
+
+func TestRecordWith_Tee(t *testing.T) {
+	outer := RecordStdout()
+	defer outer.Release()
+
+	inner, err := RecordWith(RecordOptions{Stdout: true, Tee: true})
+	require.NoError(t, err)
+	defer inner.Release()
+
+	fmt.Fprint(os.Stdout, "hello")
+	assert.Equal(t, "hello", inner.Output())
+	assert.Equal(t, "hello", outer.Output(), "the recorded output should be written to the redirected stream")
+}
+
+func TestRecordWith_NoStreams(t *testing.T) {
+	_, err := RecordWith(RecordOptions{})
+	require.Error(t, err)
+}
+
+func TestRecordWith_Parallel(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := RecordOutput()
+			rec.Output()
+		}()
+	}
+	wg.Wait()
+
+	assert.Empty(t, recordings.stdout, "all recordings should be released")
+	assert.Empty(t, recordings.stderr, "all recordings should be released")
+}
+
+func TestRecordWith_ConcurrentWrites(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the streams are replaced in place on windows")
+	}
+
+	outer := RecordStderr()
+	defer outer.Release()
+
+	// Write while recordings are started and released
+	const writes = 1000
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < writes; i++ {
+			fmt.Fprintln(os.Stderr, "line")
+		}
+	}()
+
+	var recorded string
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+		}
+		inner := RecordStderr()
+		recorded += inner.Output()
+	}
+	recorded += outer.Output()
+
+	assert.Equal(t, writes, strings.Count(recorded, "line\n"), "every write should be recorded once")
+}
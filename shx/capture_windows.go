@@ -0,0 +1,25 @@
+//go:build windows
+// +build windows
+
+package shx
+
+import "os"
+
+// saveStream returns a copy of the stream, so that it can be restored after
+// the stream is redirected.
+func saveStream(f *os.File) (*os.File, error) {
+	saved := *f
+	return &saved, nil
+}
+
+// redirectStream replaces the stream in place with the destination. Windows
+// cannot replace the handle of the stream, so this is not synchronized with
+// other goroutines that write to the stream.
+func redirectStream(f *os.File, dest *os.File) error {
+	*f = *dest
+	return nil
+}
+
+// releaseStream does nothing, the copy returned by saveStream shares the
+// handle of the stream.
+func releaseStream(saved *os.File) {}
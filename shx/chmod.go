@@ -0,0 +1,84 @@
+package shx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type ChmodOption int
+
+const (
+	ChmodDefault ChmodOption = iota
+	// ChmodRecursive changes the permissions of directories and their contents.
+	ChmodRecursive
+	// ChmodDryRun logs what would be changed, without changing anything.
+	ChmodDryRun
+)
+
+// ChmodOptions are the set of options that can be passed to ChmodWith.
+type ChmodOptions struct {
+	// Recursive changes the permissions of directories and their contents.
+	Recursive bool
+
+	// DryRun logs what would be changed, without changing anything.
+	DryRun bool
+}
+
+// Chmod changes the permissions of files or directories with the specified
+// set of ChmodOption. The pattern may use globbing, which is resolved with
+// filepath.Glob, and ** to match zero or more directories. Returns an error
+// when the pattern does not match any files.
+func Chmod(pattern string, mode os.FileMode, opts ...ChmodOption) error {
+	var combinedOpts ChmodOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return ChmodWith(pattern, mode, ChmodOptions{
+		Recursive: combinedOpts&ChmodRecursive == ChmodRecursive,
+		DryRun:    combinedOpts&ChmodDryRun == ChmodDryRun,
+	})
+}
+
+// ChmodWith changes the permissions of files or directories with the
+// specified ChmodOptions.
+func ChmodWith(pattern string, mode os.FileMode, opts ChmodOptions) error {
+	items, err := glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 {
+		return fmt.Errorf("no such file or directory '%s'", pattern)
+	}
+
+	if opts.Recursive {
+		items = withoutNestedMatches(items)
+	}
+	for _, item := range items {
+		if opts.Recursive {
+			logFileOperation(opts.DryRun, "chmod -R %#o %s", mode.Perm(), item.Path)
+		} else {
+			logFileOperation(opts.DryRun, "chmod %#o %s", mode.Perm(), item.Path)
+		}
+		if opts.DryRun {
+			continue
+		}
+
+		if !opts.Recursive {
+			err = os.Chmod(item.Path, mode)
+		} else {
+			err = filepath.Walk(item.Path, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				return os.Chmod(path, mode)
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("could not change the permissions of %s: %w", item.Path, err)
+		}
+	}
+	return nil
+}
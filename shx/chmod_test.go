@@ -0,0 +1,66 @@
+package shx
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChmod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows does not support unix permissions")
+	}
+
+	assertMode := func(t *testing.T, path string, want os.FileMode) {
+		t.Helper()
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, want, info.Mode().Perm(), "incorrect permissions for %s", path)
+	}
+
+	t.Run("glob", func(t *testing.T) {
+		tmp := copyTestData(t)
+		require.NoError(t, os.Chmod(filepath.Join(tmp, "a2.txt"), 0644))
+
+		err := Chmod(filepath.Join(tmp, "**/*1.txt"), 0600)
+		require.NoError(t, err)
+		assertMode(t, filepath.Join(tmp, "a1.txt"), 0600)
+		assertMode(t, filepath.Join(tmp, "ab/ab1.txt"), 0600)
+		assertMode(t, filepath.Join(tmp, "a2.txt"), 0644)
+	})
+
+	t.Run("recursive", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Chmod(filepath.Join(tmp, "ab"), 0750, ChmodRecursive)
+		require.NoError(t, err)
+		assertMode(t, filepath.Join(tmp, "ab"), 0750)
+		assertMode(t, filepath.Join(tmp, "ab/ab1.txt"), 0750)
+		assertMode(t, filepath.Join(tmp, "ab/ab2.txt"), 0750)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Chmod(filepath.Join(tmp, "missing"), 0600)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no such file or directory")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		tmp := copyTestData(t)
+		require.NoError(t, os.Chmod(filepath.Join(tmp, "a1.txt"), 0644))
+
+		stderr := RecordStderr()
+		defer stderr.Release()
+
+		err := Chmod(filepath.Join(tmp, "a1.txt"), 0600, ChmodDryRun)
+		require.NoError(t, err)
+		assert.Contains(t, stderr.Output(), "[dry run] chmod 0600 "+filepath.Join(tmp, "a1.txt"))
+		assertMode(t, filepath.Join(tmp, "a1.txt"), 0644)
+	})
+}
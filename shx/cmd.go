@@ -42,3 +42,8 @@ func OutputE(cmd string, args ...string) (string, error) {
 func OutputV(cmd string, args ...string) (string, error) {
 	return defaultCommand.OutputV(cmd, args...)
 }
+
+// OutputWithInput is like Output, but writes the input to the command's stdin.
+func OutputWithInput(input string, cmd string, args ...string) (string, error) {
+	return defaultCommand.OutputWithInput(input, cmd, args...)
+}
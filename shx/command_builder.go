@@ -1,5 +1,10 @@
 package shx
 
+import (
+	"os"
+	"strings"
+)
+
 // CommandBuilder creates PreparedCommand's with common configuration
 // such as always stopping on errors, running a set of commands in a
 // directory, or using a set of environment variables.
@@ -7,6 +12,14 @@ type CommandBuilder struct {
 	StopOnError bool
 	Env         []string
 	Dir         string
+
+	// BeforeHooks are called, in order, before each command is executed.
+	// Use them to modify every command, such as injecting common flags.
+	BeforeHooks []BeforeHook
+
+	// AfterHooks are called, in order, after each command is executed.
+	// Use them to observe every command, such as timing or auditing commands.
+	AfterHooks []AfterHook
 }
 
 // Command creates a command using common configuration.
@@ -14,7 +27,48 @@ func (b *CommandBuilder) Command(cmd string, args ...string) PreparedCommand {
 	return Command(cmd, args...).
 		Must(b.StopOnError).
 		Env(b.Env...).
-		In(b.Dir)
+		In(b.Dir).
+		Before(b.BeforeHooks...).
+		After(b.AfterHooks...)
+}
+
+// Before registers hooks that are called before each command is executed.
+func (b *CommandBuilder) Before(hooks ...BeforeHook) {
+	b.BeforeHooks = append(b.BeforeHooks, hooks...)
+}
+
+// After registers hooks that are called after each command is executed.
+func (b *CommandBuilder) After(hooks ...AfterHook) {
+	b.AfterHooks = append(b.AfterHooks, hooks...)
+}
+
+// LoadDotenv reads a dotenv file and adds the variables to Env. By default,
+// variables that are already defined in Env or in the current environment are
+// not replaced, use DotenvOverride to replace them. See ReadDotenv for the
+// supported syntax.
+func (b *CommandBuilder) LoadDotenv(path string, opts ...DotenvOption) error {
+	lookupEnv := func(key string) (string, bool) {
+		if i := indexEnv(b.Env, key); i >= 0 {
+			return strings.TrimPrefix(b.Env[i][len(key):], "="), true
+		}
+		return os.LookupEnv(key)
+	}
+	vars, err := readDotenv(path, lookupEnv, opts)
+	if err != nil {
+		return err
+	}
+
+	override := combineDotenvOptions(opts)&DotenvOverride == DotenvOverride
+	for _, v := range vars {
+		key := getEnvKey(v)
+		if !override {
+			if _, ok := os.LookupEnv(key); ok || indexEnv(b.Env, key) >= 0 {
+				continue
+			}
+		}
+		b.Env = append(b.Env, v)
+	}
+	return nil
 }
 
 // Run the given command, directing stderr to this program's stderr and
@@ -57,3 +111,8 @@ func (b *CommandBuilder) OutputE(cmd string, args ...string) (string, error) {
 func (b *CommandBuilder) OutputV(cmd string, args ...string) (string, error) {
 	return b.Command(cmd, args...).OutputV()
 }
+
+// OutputWithInput is like Output, but writes the input to the command's stdin.
+func (b *CommandBuilder) OutputWithInput(input string, cmd string, args ...string) (string, error) {
+	return b.Command(cmd, args...).StdinString(input).Output()
+}
@@ -0,0 +1,27 @@
+package shx_test
+
+import (
+	"log"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+func ExampleCommandBuilder_After() {
+	var cmds shx.CommandBuilder
+
+	// Inject a common flag into every command
+	cmds.Before(func(c *shx.PreparedCommand) error {
+		c.Args("-v")
+		return nil
+	})
+
+	// Log how long every command took
+	cmds.After(func(c shx.PreparedCommand, result shx.CommandResult) {
+		log.Printf("%s took %s (exit code %d)\n", c, result.Duration, result.ExitCode)
+	})
+
+	err := cmds.RunV("go", "vet", "./...")
+	if err != nil {
+		log.Fatal(err)
+	}
+}
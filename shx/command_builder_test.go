@@ -1,9 +1,12 @@
 package shx
 
 import (
+	"errors"
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestCommandBuilder_Command(t *testing.T) {
@@ -18,3 +21,144 @@ func TestCommandBuilder_Command(t *testing.T) {
 	assert.Contains(t, cmd.Cmd.Env, "a=1", "incorrect Env")
 	assert.Equal(t, "tmp", cmd.Cmd.Dir, "incorrect Dir")
 }
+
+func TestCommandBuilder_LoadDotenv(t *testing.T) {
+	os.Setenv("MAGEX_TEST_EXISTING", "env")
+	defer os.Unsetenv("MAGEX_TEST_EXISTING")
+
+	path := writeDotenv(t, "A=file\nB=file\nMAGEX_TEST_EXISTING=file\n")
+
+	t.Run("default", func(t *testing.T) {
+		b := CommandBuilder{Env: []string{"A=builder"}}
+		err := b.LoadDotenv(path)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"A=builder", "B=file"}, b.Env)
+
+		cmd := b.Command("go", "version")
+		assert.Equal(t, "env", cmd.Getenv("MAGEX_TEST_EXISTING"), "the environment should take precedence over the file")
+	})
+
+	t.Run("override", func(t *testing.T) {
+		b := CommandBuilder{Env: []string{"A=builder"}}
+		err := b.LoadDotenv(path, DotenvOverride)
+		require.NoError(t, err)
+
+		cmd := b.Command("go", "version")
+		assert.Equal(t, "file", cmd.Getenv("A"))
+		assert.Equal(t, "file", cmd.Getenv("B"))
+		assert.Equal(t, "file", cmd.Getenv("MAGEX_TEST_EXISTING"))
+	})
+}
+
+func TestCommandBuilder_LoadDotenv_Interpolation(t *testing.T) {
+	path := writeDotenv(t, "A=file\nREF=$A\n")
+
+	b := CommandBuilder{Env: []string{"A=builder"}}
+	err := b.LoadDotenv(path)
+	require.NoError(t, err)
+
+	cmd := b.Command("go", "version")
+	assert.Equal(t, "builder", cmd.Getenv("A"))
+	assert.Equal(t, "builder", cmd.Getenv("REF"), "interpolation should use the same value as the variable")
+}
+
+func TestCommandBuilder_Hooks(t *testing.T) {
+	t.Run("before and after", func(t *testing.T) {
+		var b CommandBuilder
+		b.Before(func(c *PreparedCommand) error {
+			c.Args("-json")
+			return nil
+		})
+
+		var results []CommandResult
+		var ran []string
+		b.After(func(c PreparedCommand, result CommandResult) {
+			ran = append(ran, c.String())
+			results = append(results, result)
+		})
+
+		err := b.Command("go", "env", "GOOS").RunE()
+		require.NoError(t, err)
+
+		require.Len(t, results, 1, "the after hook should be called once")
+		assert.Equal(t, []string{"go env GOOS -json"}, ran, "the before hook should modify the command")
+		assert.True(t, results[0].Ran)
+		assert.Equal(t, 0, results[0].ExitCode)
+		assert.NoError(t, results[0].Err)
+		assert.False(t, results[0].Start.IsZero(), "Start should be set")
+	})
+
+	t.Run("failed command", func(t *testing.T) {
+		var result CommandResult
+		b := CommandBuilder{}
+		b.After(func(c PreparedCommand, r CommandResult) {
+			result = r
+		})
+
+		err := b.Command("go", "fakecommand").RunE()
+		require.Error(t, err)
+		assert.True(t, result.Ran)
+		assert.Equal(t, 2, result.ExitCode)
+		assert.Equal(t, err, result.Err)
+	})
+
+	t.Run("before hook fails", func(t *testing.T) {
+		var result CommandResult
+		called := false
+		b := CommandBuilder{}
+		b.Before(func(c *PreparedCommand) error {
+			return errors.New("not allowed")
+		})
+		b.After(func(c PreparedCommand, r CommandResult) {
+			called = true
+			result = r
+		})
+
+		err := b.Command("go", "version").RunE()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not allowed")
+		assert.True(t, called, "the after hook should be called when a before hook fails")
+		assert.False(t, result.Ran)
+	})
+
+	t.Run("before hook fails is traced", func(t *testing.T) {
+		StartTrace()
+		defer StopTrace()
+
+		b := CommandBuilder{}
+		b.Before(func(c *PreparedCommand) error {
+			return errors.New("not allowed")
+		})
+
+		require.Error(t, b.Command("go", "version").RunE())
+
+		events := TraceEvents()
+		require.Len(t, events, 1, "the failed command should be traced")
+		assert.Equal(t, "go version", events[0].Command)
+		assert.NotEqual(t, 0, events[0].ExitCode)
+	})
+
+	t.Run("before hook sets StopOnError", func(t *testing.T) {
+		b := CommandBuilder{}
+		b.Before(func(c *PreparedCommand) error {
+			c.StopOnError = true
+			return nil
+		})
+
+		assert.Panics(t, func() {
+			b.Command("go", "fakecommand").RunE()
+		}, "StopOnError set by the before hook should be used")
+	})
+
+	t.Run("hooks are not shared", func(t *testing.T) {
+		var first, second []string
+		cmd := Command("go", "version")
+		a := cmd.After(func(c PreparedCommand, r CommandResult) { first = append(first, "a") })
+		cmd.After(func(c PreparedCommand, r CommandResult) { second = append(second, "b") })
+
+		require.NoError(t, a.RunE())
+		assert.Equal(t, []string{"a"}, first)
+		assert.Empty(t, second)
+	})
+}
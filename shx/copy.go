@@ -16,12 +16,50 @@ const (
 	CopyRecursive
 )
 
+// CopyOptions are the set of options that can be passed to CopyWith.
+type CopyOptions struct {
+	// NoOverwrite does not overwrite existing files in the destination.
+	NoOverwrite bool
+
+	// Recursive copies the contents of directories.
+	Recursive bool
+
+	// Include limits the copied files to those matching at least one
+	// gitignore-style pattern, such as *.go or docs/. Directories are only
+	// created in the destination when they contain an included file.
+	// When empty, all files are copied.
+	Include []string
+
+	// Exclude skips files and directories matching any gitignore-style pattern,
+	// such as .git, node_modules or **/testdata.
+	Exclude []string
+}
+
 // Copy a file or directory with the specified set of CopyOption.
-// The source may use globbing, which is resolved with filepath.Glob.
+// The source may use globbing, which is resolved with filepath.Glob, and
+// ** to match zero or more directories. When the source uses **, the matched
+// paths are copied into the destination preserving their directory structure
+// relative to the portion of the source without wildcards.
 // Notes:
 //   * Does not copy file owner/group.
 func Copy(src string, dest string, opts ...CopyOption) error {
-	items, err := filepath.Glob(src)
+	var combinedOpts CopyOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return CopyWith(src, dest, CopyOptions{
+		NoOverwrite: combinedOpts&CopyNoOverwrite == CopyNoOverwrite,
+		Recursive:   combinedOpts&CopyRecursive == CopyRecursive,
+	})
+}
+
+// CopyWith copies a file or directory with the specified CopyOptions.
+// Include and exclude patterns are evaluated against the path of each file
+// relative to the destination directory, e.g. copying src/* into dest checks
+// the pattern against paths such as a.go and pkg/b.go.
+func CopyWith(src string, dest string, opts CopyOptions) error {
+	items, err := glob(src)
 	if err != nil {
 		return err
 	}
@@ -30,18 +68,19 @@ func Copy(src string, dest string, opts ...CopyOption) error {
 		return fmt.Errorf("no such file or directory '%s'", src)
 	}
 
-	var combinedOpts CopyOption
-	for _, opt := range opts {
-		combinedOpts |= opt
-	}
-
 	// Check if the destination exists, e.g. if we are copying to /tmp/foo, /tmp should already exist
 	if _, err := os.Stat(filepath.Dir(dest)); err != nil {
 		return err
 	}
 
+	// Don't copy a file twice when both it and its parent directory matched
+	if opts.Recursive {
+		items = withoutNestedMatches(items)
+	}
+
+	filter := pathFilter{Include: opts.Include, Exclude: opts.Exclude}
 	for _, item := range items {
-		err := copyFileOrDirectory(item, dest, combinedOpts)
+		err := copyFileOrDirectory(item, dest, opts, filter)
 		if err != nil {
 			return err
 		}
@@ -50,39 +89,85 @@ func Copy(src string, dest string, opts ...CopyOption) error {
 	return nil
 }
 
-func copyFileOrDirectory(src string, dest string, opts CopyOption) error {
+func copyFileOrDirectory(item globMatch, dest string, opts CopyOptions, filter pathFilter) error {
 	// If the destination is a directory that exists,
 	// copy into the directory.
 	destInfo, err := os.Stat(dest)
 	if err == nil && destInfo.IsDir() {
-		dest = filepath.Join(dest, filepath.Base(src))
+		dest = filepath.Join(dest, item.Rel)
+
+		// Items matched with ** may be nested in directories that aren't in the destination yet
+		if err := mkdirLike(filepath.Dir(item.Path), filepath.Dir(dest)); err != nil {
+			return err
+		}
 	}
 
-	return filepath.Walk(src, func(srcPath string, srcInfo os.FileInfo, err error) error {
+	return filepath.Walk(item.Path, func(srcPath string, srcInfo os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
 		// Only copy the first item if CopyRecursive wasn't set
-		if opts&CopyRecursive != CopyRecursive && src != srcPath {
+		if !opts.Recursive && item.Path != srcPath {
 			return nil
 		}
 
-		relPath, err := filepath.Rel(src, srcPath)
+		relPath, err := filepath.Rel(item.Path, srcPath)
 		if err != nil {
-			return fmt.Errorf("error determining the relative path between %s and %s: %w", src, srcPath, err)
+			return fmt.Errorf("error determining the relative path between %s and %s: %w", item.Path, srcPath, err)
 		}
 		destPath := filepath.Join(dest, relPath)
 
+		filterPath := filepath.Join(item.Rel, relPath)
+		if filter.Excluded(filterPath, srcInfo.IsDir()) {
+			if srcInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		if srcInfo.IsDir() {
+			// When filtering with include patterns, only create directories that have included files
+			if !filter.Included(filterPath, true) {
+				return nil
+			}
 			return os.MkdirAll(destPath, srcInfo.Mode())
 		}
 
+		if !filter.Included(filterPath, false) {
+			return nil
+		}
+		if err := mkdirLike(filepath.Dir(srcPath), filepath.Dir(destPath)); err != nil {
+			return err
+		}
 		return copyFile(srcPath, destPath, opts)
 	})
 }
 
-func copyFile(src string, dest string, opts CopyOption) error {
+// mkdirLike creates the directory, and any missing parent directories, using
+// the permissions of the corresponding source directories.
+func mkdirLike(srcDir string, destDir string) error {
+	if _, err := os.Stat(destDir); err == nil {
+		return nil
+	}
+
+	if err := mkdirLike(filepath.Dir(srcDir), filepath.Dir(destDir)); err != nil {
+		return err
+	}
+
+	srcInfo, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+
+	err = os.Mkdir(destDir, srcInfo.Mode().Perm())
+	if err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+func copyFile(src string, dest string, opts CopyOptions) error {
 	srcInfo, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -95,7 +180,7 @@ func copyFile(src string, dest string, opts CopyOption) error {
 	defer srcF.Close()
 
 	// Check if we should skip existing files
-	overwrite := opts&CopyNoOverwrite != CopyNoOverwrite
+	overwrite := !opts.NoOverwrite
 	createOpts := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
 	if !overwrite { // Return an error if the file exists
 		createOpts |= os.O_EXCL
@@ -21,3 +21,21 @@ func ExampleCopy() {
 	// Recursively copy a directory into TEMP
 	shx.Copy("a", "/tmp", shx.CopyRecursive)
 }
+
+func ExampleCopyWith() {
+	// Recursively copy the source tree into a build context,
+	// skipping version control, dependencies and test fixtures
+	shx.CopyWith("src", "/tmp/build", shx.CopyOptions{
+		Recursive: true,
+		Exclude:   []string{".git", "node_modules", "testdata/"},
+	})
+
+	// Copy all go files, preserving their directory structure
+	shx.Copy("src/**/*.go", "/tmp/build")
+
+	// Copy only the markdown files from the docs directory
+	shx.CopyWith("docs", "/tmp/build", shx.CopyOptions{
+		Recursive: true,
+		Include:   []string{"*.md"},
+	})
+}
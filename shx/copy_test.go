@@ -139,6 +139,88 @@ func TestCopy(t *testing.T) {
 	})
 }
 
+func TestCopyWith(t *testing.T) {
+	t.Run("doublestar preserves directory structure", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		err = Copy("testdata/copy/a/**/*1.txt", tmp)
+		require.NoError(t, err, "Copy with ** failed")
+
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+		assertFile(t, filepath.Join(tmp, "ab/ab1.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a2.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "ab/ab2.txt"))
+	})
+
+	t.Run("doublestar recursive does not copy twice", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		err = CopyWith("testdata/copy/**", tmp, CopyOptions{Recursive: true, NoOverwrite: true})
+		require.NoError(t, err, "Copy with ** failed")
+
+		assertFile(t, filepath.Join(tmp, "a/a1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab2.txt"))
+		assertFile(t, filepath.Join(tmp, "partial-dest/ab/ab1.txt"))
+	})
+
+	t.Run("exclude", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		opts := CopyOptions{
+			Recursive: true,
+			Exclude:   []string{"ab", "a2.txt"},
+		}
+		err = CopyWith("testdata/copy/a", tmp, opts)
+		require.NoError(t, err, "Copy with exclude failed")
+
+		assertFile(t, filepath.Join(tmp, "a/a1.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a/a2.txt"))
+		assert.NoDirExists(t, filepath.Join(tmp, "a/ab"))
+	})
+
+	t.Run("exclude glob item", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		opts := CopyOptions{
+			Recursive: true,
+			Exclude:   []string{"/ab/"},
+		}
+		err = CopyWith("testdata/copy/a/*", tmp, opts)
+		require.NoError(t, err, "Copy with exclude failed")
+
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+		assertFile(t, filepath.Join(tmp, "a2.txt"))
+		assert.NoDirExists(t, filepath.Join(tmp, "ab"))
+	})
+
+	t.Run("include", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		opts := CopyOptions{
+			Recursive: true,
+			Include:   []string{"*2.txt"},
+		}
+		err = CopyWith("testdata/copy", tmp, opts)
+		require.NoError(t, err, "Copy with include failed")
+
+		assertFile(t, filepath.Join(tmp, "copy/a/a2.txt"))
+		assertFile(t, filepath.Join(tmp, "copy/a/ab/ab2.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "copy/a/a1.txt"))
+		assert.NoDirExists(t, filepath.Join(tmp, "copy/partial-dest"), "directories without included files should not be created")
+		assert.NoDirExists(t, filepath.Join(tmp, "copy/directory-conflict"), "directories without included files should not be created")
+	})
+}
+
 func TestCopy_CopyNoRecursive(t *testing.T) {
 	tmp, err := ioutil.TempDir("", "magex")
 	require.NoError(t, err, "could not create temp directory for test")
@@ -0,0 +1,341 @@
+package shx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+type DotenvOption int
+
+const (
+	// DotenvDefault does not replace environment variables that are already
+	// set, so that the real environment takes precedence over the file.
+	DotenvDefault DotenvOption = iota
+	// DotenvOverride replaces environment variables that are already set with
+	// the values from the file.
+	DotenvOverride
+)
+
+// ReadDotenv parses a dotenv file, returning the variables as KEY=VALUE
+// assignments in the order that they are defined. The file supports:
+//   - Comments starting with #, on their own line or after an unquoted value.
+//   - An optional export prefix, e.g. export KEY=VALUE.
+//   - Single quoted values, which are used literally.
+//   - Double quoted values, which may contain escape sequences such as \n,
+//     and may span multiple lines.
+//   - Variable interpolation with $KEY or ${KEY} in unquoted and double quoted
+//     values, using the value that the variable has after the file is loaded.
+//     By default, a variable that is already set in the current environment
+//     takes precedence over its definition earlier in the file, use
+//     DotenvOverride to use the value from the file instead.
+func ReadDotenv(path string, opts ...DotenvOption) ([]string, error) {
+	return readDotenv(path, os.LookupEnv, opts)
+}
+
+// readDotenv parses a dotenv file, where lookupEnv finds the variables that
+// are already set, for interpolation.
+func readDotenv(path string, lookupEnv func(key string) (string, bool), opts []DotenvOption) ([]string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read dotenv file %s: %w", path, err)
+	}
+
+	p := dotenvParser{
+		src:       string(contents),
+		line:      1,
+		vars:      make(map[string]string),
+		lookupEnv: lookupEnv,
+		override:  combineDotenvOptions(opts)&DotenvOverride == DotenvOverride,
+	}
+	vars, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("error parsing dotenv file %s: %w", path, err)
+	}
+	return vars, nil
+}
+
+// LoadDotenv reads a dotenv file and sets the variables in the current process.
+// By default, variables that are already set are not replaced, use
+// DotenvOverride to replace them. See ReadDotenv for the supported syntax.
+func LoadDotenv(path string, opts ...DotenvOption) error {
+	vars, err := ReadDotenv(path, opts...)
+	if err != nil {
+		return err
+	}
+
+	override := combineDotenvOptions(opts)&DotenvOverride == DotenvOverride
+	for _, v := range vars {
+		key := getEnvKey(v)
+		if _, ok := os.LookupEnv(key); ok && !override {
+			continue
+		}
+		if err := os.Setenv(key, strings.TrimPrefix(v[len(key):], "=")); err != nil {
+			return fmt.Errorf("could not set %s from dotenv file %s: %w", key, path, err)
+		}
+	}
+	return nil
+}
+
+func combineDotenvOptions(opts []DotenvOption) DotenvOption {
+	var combinedOpts DotenvOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+	return combinedOpts
+}
+
+// dotenvParser reads variable assignments from the contents of a dotenv file.
+type dotenvParser struct {
+	src  string
+	pos  int
+	line int
+
+	// vars are the variables parsed so far, used for interpolation
+	vars map[string]string
+
+	// lookupEnv finds variables that are already set, which take precedence
+	// over vars unless override is set
+	lookupEnv func(key string) (string, bool)
+	override  bool
+}
+
+func (p *dotenvParser) parse() ([]string, error) {
+	var result []string
+	for {
+		p.skipWhitespace(true)
+		if p.eof() {
+			return result, nil
+		}
+
+		if p.peek() == '#' {
+			p.skipLine()
+			continue
+		}
+
+		key := p.readKey()
+		if key == "export" {
+			p.skipWhitespace(false)
+			if !p.eof() && p.peek() != '=' {
+				key = p.readKey()
+			}
+		}
+		if key == "" {
+			return nil, fmt.Errorf("line %d: expected a variable name", p.line)
+		}
+
+		p.skipWhitespace(false)
+		if p.eof() || p.peek() != '=' {
+			return nil, fmt.Errorf("line %d: expected = after %s", p.line, key)
+		}
+		p.pos++
+		p.skipWhitespace(false)
+
+		value, err := p.readValue()
+		if err != nil {
+			return nil, err
+		}
+
+		p.vars[key] = value
+		result = append(result, key+"="+value)
+	}
+}
+
+func (p *dotenvParser) eof() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *dotenvParser) peek() byte {
+	return p.src[p.pos]
+}
+
+func (p *dotenvParser) next() byte {
+	c := p.src[p.pos]
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return c
+}
+
+// skipWhitespace advances past spaces and tabs, and optionally newlines.
+func (p *dotenvParser) skipWhitespace(newlines bool) {
+	for !p.eof() {
+		switch p.peek() {
+		case ' ', '\t', '\r':
+			p.next()
+		case '\n':
+			if !newlines {
+				return
+			}
+			p.next()
+		default:
+			return
+		}
+	}
+}
+
+func (p *dotenvParser) skipLine() {
+	for !p.eof() && p.next() != '\n' {
+	}
+}
+
+func (p *dotenvParser) readKey() string {
+	start := p.pos
+	for !p.eof() && isEnvKeyChar(p.peek()) {
+		p.next()
+	}
+	return p.src[start:p.pos]
+}
+
+// isEnvKeyChar determines if the character is allowed in a variable name in a
+// dotenv file.
+func isEnvKeyChar(c byte) bool {
+	return c == '.' || c == '-' || isEnvNameChar(c)
+}
+
+// isEnvNameChar determines if the character is allowed in a variable name
+// referenced with $KEY. Use ${KEY} for names with other characters.
+func isEnvNameChar(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *dotenvParser) readValue() (string, error) {
+	if p.eof() {
+		return "", nil
+	}
+
+	var value string
+	var err error
+	switch p.peek() {
+	case '\'':
+		value, err = p.readSingleQuoted()
+	case '"':
+		value, err = p.readDoubleQuoted()
+	default:
+		return p.readUnquoted(), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Only whitespace or a comment may follow a quoted value
+	p.skipWhitespace(false)
+	if !p.eof() && p.peek() != '\n' && p.peek() != '#' {
+		return "", fmt.Errorf("line %d: unexpected character %q after quoted value", p.line, p.peek())
+	}
+	p.skipLine()
+	return value, nil
+}
+
+func (p *dotenvParser) readSingleQuoted() (string, error) {
+	startLine := p.line
+	p.next()
+	start := p.pos
+	for !p.eof() {
+		if p.next() == '\'' {
+			return p.src[start : p.pos-1], nil
+		}
+	}
+	return "", fmt.Errorf("line %d: unterminated single quoted value", startLine)
+}
+
+func (p *dotenvParser) readDoubleQuoted() (string, error) {
+	startLine := p.line
+	p.next()
+	var value strings.Builder
+	for !p.eof() {
+		c := p.next()
+		switch c {
+		case '"':
+			return value.String(), nil
+		case '\\':
+			if p.eof() {
+				break
+			}
+			switch e := p.next(); e {
+			case 'n':
+				value.WriteByte('\n')
+			case 'r':
+				value.WriteByte('\r')
+			case 't':
+				value.WriteByte('\t')
+			case '"', '\\', '$':
+				value.WriteByte(e)
+			default:
+				value.WriteByte('\\')
+				value.WriteByte(e)
+			}
+		case '$':
+			value.WriteString(p.readInterpolation())
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("line %d: unterminated double quoted value", startLine)
+}
+
+func (p *dotenvParser) readUnquoted() string {
+	var value strings.Builder
+	for !p.eof() {
+		c := p.peek()
+		if c == '\n' {
+			break
+		}
+		// An inline comment must be preceded by whitespace, e.g. KEY=VALUE # comment
+		if c == '#' && (p.src[p.pos-1] == ' ' || p.src[p.pos-1] == '\t') {
+			p.skipLine()
+			break
+		}
+
+		p.next()
+		if c == '$' {
+			value.WriteString(p.readInterpolation())
+		} else {
+			value.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(value.String())
+}
+
+// readInterpolation reads the variable reference following a $, returning its
+// value. A $ that isn't followed by a variable name is returned as-is.
+func (p *dotenvParser) readInterpolation() string {
+	if p.eof() {
+		return "$"
+	}
+
+	if p.peek() == '{' {
+		end := strings.IndexAny(p.src[p.pos:], "}\n")
+		if end < 0 || p.src[p.pos+end] != '}' {
+			return "$"
+		}
+		key := p.src[p.pos+1 : p.pos+end]
+		p.pos += end + 1
+		return p.lookup(key)
+	}
+
+	start := p.pos
+	for !p.eof() && isEnvNameChar(p.peek()) {
+		p.next()
+	}
+	key := p.src[start:p.pos]
+	if key == "" {
+		return "$"
+	}
+	return p.lookup(key)
+}
+
+// lookup returns the value of a variable, with the same precedence used when
+// the file is loaded.
+func (p *dotenvParser) lookup(key string) string {
+	existing, isSet := p.lookupEnv(key)
+	if isSet && !p.override {
+		return existing
+	}
+	if value, ok := p.vars[key]; ok {
+		return value
+	}
+	return existing
+}
@@ -0,0 +1,26 @@
+package shx_test
+
+import (
+	"log"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+func ExampleLoadDotenv() {
+	// Load local settings into the current process, without replacing
+	// variables that are already set in the environment
+	if err := shx.LoadDotenv(".env"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleCommandBuilder_LoadDotenv() {
+	// Run commands with the settings from .env, replacing variables
+	// that are already set in the environment
+	var b shx.CommandBuilder
+	if err := b.LoadDotenv(".env", shx.DotenvOverride); err != nil {
+		log.Fatal(err)
+	}
+
+	b.RunV("go", "test", "./...")
+}
@@ -0,0 +1,149 @@
+package shx
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeDotenv(t *testing.T, contents string) string {
+	t.Helper()
+
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	path := filepath.Join(tmp, ".env")
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	return path
+}
+
+func TestReadDotenv(t *testing.T) {
+	os.Setenv("MAGEX_TEST_AMBIENT", "ambient")
+	defer os.Unsetenv("MAGEX_TEST_AMBIENT")
+
+	path := writeDotenv(t, `# A comment
+PLAIN=value
+SPACES = padded value   
+export EXPORTED=1
+EMPTY=
+COMMENT=value # inline comment
+HASH=a#b
+SINGLE='$PLAIN is \n literal' # comment
+DOUBLE="line1\nline2 \"quoted\" \$PLAIN"
+MULTILINE="first
+second"
+INTERPOLATE=${PLAIN}-$EXPORTED-$MAGEX_TEST_AMBIENT
+INTERPOLATE_QUOTED="${PLAIN}/bin"
+MISSING=$MAGEX_TEST_MISSING
+DOLLAR=$ 5
+`)
+
+	vars, err := ReadDotenv(path)
+	require.NoError(t, err)
+
+	want := []string{
+		"PLAIN=value",
+		"SPACES=padded value",
+		"EXPORTED=1",
+		"EMPTY=",
+		"COMMENT=value",
+		"HASH=a#b",
+		`SINGLE=$PLAIN is \n literal`,
+		"DOUBLE=line1\nline2 \"quoted\" $PLAIN",
+		"MULTILINE=first\nsecond",
+		"INTERPOLATE=value-1-ambient",
+		"INTERPOLATE_QUOTED=value/bin",
+		"MISSING=",
+		"DOLLAR=$ 5",
+	}
+	assert.Equal(t, want, vars)
+}
+
+func TestReadDotenv_Invalid(t *testing.T) {
+	testcases := []struct {
+		name     string
+		contents string
+		wantErr  string
+	}{
+		{name: "missing equals", contents: "A=1\nB 2", wantErr: "line 2: expected = after B"},
+		{name: "missing key", contents: "=1", wantErr: "line 1: expected a variable name"},
+		{name: "unterminated single quote", contents: "A='1", wantErr: "line 1: unterminated single quoted value"},
+		{name: "unterminated double quote", contents: "A=\"1\n", wantErr: "line 1: unterminated double quoted value"},
+		{name: "trailing characters", contents: "A='1'2", wantErr: "line 1: unexpected character '2' after quoted value"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeDotenv(t, tc.contents)
+			_, err := ReadDotenv(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tc.wantErr)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := ReadDotenv("testdata/missing.env")
+		require.Error(t, err)
+		assert.True(t, os.IsNotExist(errors.Unwrap(err)))
+	})
+}
+
+func TestLoadDotenv(t *testing.T) {
+	path := writeDotenv(t, "MAGEX_TEST_NEW=file\nMAGEX_TEST_EXISTING=file\n")
+
+	testcases := []struct {
+		name         string
+		opts         DotenvOption
+		wantExisting string
+	}{
+		{name: "environment wins", opts: DotenvDefault, wantExisting: "env"},
+		{name: "override", opts: DotenvOverride, wantExisting: "file"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv("MAGEX_TEST_EXISTING", "env")
+			defer os.Unsetenv("MAGEX_TEST_EXISTING")
+			defer os.Unsetenv("MAGEX_TEST_NEW")
+
+			err := LoadDotenv(path, tc.opts)
+			require.NoError(t, err)
+
+			assert.Equal(t, "file", os.Getenv("MAGEX_TEST_NEW"))
+			assert.Equal(t, tc.wantExisting, os.Getenv("MAGEX_TEST_EXISTING"))
+		})
+	}
+}
+
+func TestLoadDotenv_InterpolationPrecedence(t *testing.T) {
+	path := writeDotenv(t, "MAGEX_TEST_PLAIN=file\nMAGEX_TEST_REF=$MAGEX_TEST_PLAIN\n")
+
+	testcases := []struct {
+		name      string
+		opts      DotenvOption
+		wantPlain string
+	}{
+		{name: "environment wins", opts: DotenvDefault, wantPlain: "env"},
+		{name: "override", opts: DotenvOverride, wantPlain: "file"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv("MAGEX_TEST_PLAIN", "env")
+			defer os.Unsetenv("MAGEX_TEST_PLAIN")
+			defer os.Unsetenv("MAGEX_TEST_REF")
+
+			err := LoadDotenv(path, tc.opts)
+			require.NoError(t, err)
+
+			assert.Equal(t, tc.wantPlain, os.Getenv("MAGEX_TEST_PLAIN"))
+			assert.Equal(t, tc.wantPlain, os.Getenv("MAGEX_TEST_REF"), "interpolation should use the same value as the variable")
+		})
+	}
+}
@@ -0,0 +1,8 @@
+package shx
+
+import "syscall"
+
+// dup2 is not available on every linux architecture, e.g. arm64, so use dup3.
+func dup2(oldfd int, newfd int) error {
+	return syscall.Dup3(oldfd, newfd, 0)
+}
@@ -0,0 +1,10 @@
+//go:build !windows && !linux
+// +build !windows,!linux
+
+package shx
+
+import "syscall"
+
+func dup2(oldfd int, newfd int) error {
+	return syscall.Dup2(oldfd, newfd)
+}
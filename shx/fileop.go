@@ -0,0 +1,17 @@
+package shx
+
+import (
+	"log"
+
+	"github.com/magefile/mage/mg"
+)
+
+// logFileOperation logs a change to the file system when verbose is set. During
+// a dry run the change is always logged, since nothing else happens.
+func logFileOperation(dryRun bool, format string, args ...interface{}) {
+	if dryRun {
+		log.Printf("[dry run] "+format+"\n", args...)
+	} else if mg.Verbose() {
+		log.Printf(format+"\n", args...)
+	}
+}
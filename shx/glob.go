@@ -0,0 +1,222 @@
+package shx
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// globMatch is a path resolved from a glob pattern.
+type globMatch struct {
+	// Path to the matched file or directory.
+	Path string
+
+	// Rel is the path relative to the directory that should be recreated in the
+	// destination. For patterns without **, this is the base name of Path. For
+	// patterns with **, this is the path relative to the part of the pattern
+	// that did not contain any wildcards, e.g. src/**/*.go resolves src/a/b.go to a/b.go.
+	Rel string
+}
+
+// Glob returns the paths of all files and directories matching the pattern.
+// It extends filepath.Glob with support for ** which matches zero or more
+// directories, e.g. src/**/*.go.
+func Glob(pattern string) ([]string, error) {
+	matches, err := glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]string, len(matches))
+	for i, match := range matches {
+		items[i] = match.Path
+	}
+	return items, nil
+}
+
+// glob returns the items matching the pattern. It extends filepath.Glob with
+// support for ** which matches zero or more directories.
+func glob(pattern string) ([]globMatch, error) {
+	segments := strings.Split(filepath.ToSlash(pattern), "/")
+	if !containsDoublestar(segments) {
+		items, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		matches := make([]globMatch, len(items))
+		for i, item := range items {
+			matches[i] = globMatch{Path: item, Rel: filepath.Base(item)}
+		}
+		return matches, nil
+	}
+
+	// Validate the pattern up front, path.Match only reports a bad pattern when it is evaluated
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	// Walk from the portion of the pattern that does not contain wildcards
+	i := 0
+	for ; i < len(segments); i++ {
+		if hasMeta(segments[i]) {
+			break
+		}
+	}
+	base := strings.Join(segments[:i], "/")
+	if base == "" && i > 0 {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	base = filepath.FromSlash(base)
+	wildcards := segments[i:]
+
+	var matches []globMatch
+	filepath.Walk(base, func(itemPath string, info os.FileInfo, err error) error {
+		// Mimic filepath.Glob and ignore I/O errors
+		if err != nil || itemPath == base {
+			return nil
+		}
+
+		rel, err := filepath.Rel(base, itemPath)
+		if err != nil {
+			return nil
+		}
+
+		if matchSegments(wildcards, strings.Split(filepath.ToSlash(rel), "/")) {
+			matches = append(matches, globMatch{Path: itemPath, Rel: rel})
+		}
+		return nil
+	})
+
+	return matches, nil
+}
+
+// withoutNestedMatches removes items that are contained in another matched
+// directory. The items must be sorted, so that a directory precedes its contents.
+func withoutNestedMatches(items []globMatch) []globMatch {
+	result := make([]globMatch, 0, len(items))
+	for _, item := range items {
+		if len(result) > 0 {
+			parent := result[len(result)-1].Path
+			if strings.HasPrefix(item.Path, parent+string(os.PathSeparator)) {
+				continue
+			}
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+func containsDoublestar(segments []string) bool {
+	for _, segment := range segments {
+		if segment == "**" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasMeta reports whether the path segment contains any of the magic characters
+// recognized by path.Match.
+func hasMeta(segment string) bool {
+	return strings.ContainsAny(segment, `*?[\`)
+}
+
+// matchSegments matches the path segments against the pattern segments, where
+// a pattern segment of ** matches zero or more path segments.
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// pathFilter decides which files to include when walking a directory, using
+// gitignore-style patterns.
+type pathFilter struct {
+	// Include limits the files to those matching at least one pattern.
+	// When empty, all files are included.
+	Include []string
+
+	// Exclude skips files, and the contents of directories, matching any pattern.
+	Exclude []string
+}
+
+// IsEmpty returns true when the filter does not define any patterns.
+func (f pathFilter) IsEmpty() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Excluded determines if the path, or any of its parent directories, matches
+// an exclude pattern. The path is relative to the directory that the patterns
+// are evaluated against.
+func (f pathFilter) Excluded(rel string, isDir bool) bool {
+	return matchAnyPattern(f.Exclude, rel, isDir)
+}
+
+// Included determines if the path, or any of its parent directories, matches
+// an include pattern. Always returns true when no include patterns are defined.
+func (f pathFilter) Included(rel string, isDir bool) bool {
+	if len(f.Include) == 0 {
+		return true
+	}
+	return matchAnyPattern(f.Include, rel, isDir)
+}
+
+func matchAnyPattern(patterns []string, rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pattern := range patterns {
+		// Check the path itself, and then its parent directories
+		for p, dir := rel, isDir; p != "." && p != "/" && p != ""; p, dir = path.Dir(p), true {
+			if matchPattern(pattern, p, dir) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPattern reports whether the slash separated relative path matches the
+// gitignore-style pattern.
+//   - A pattern ending with a slash only matches directories.
+//   - A pattern without a slash matches the name at any depth, e.g. node_modules.
+//   - A pattern with a slash is anchored to the root, e.g. /bin or docs/*.md.
+//   - ** matches zero or more directories, e.g. **/testdata or src/**/*.go.
+func matchPattern(pattern string, rel string, isDir bool) bool {
+	pattern = filepath.ToSlash(pattern)
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimRight(pattern, "/")
+	}
+
+	if strings.Contains(pattern, "/") {
+		pattern = strings.TrimPrefix(pattern, "/")
+	} else {
+		pattern = "**/" + pattern
+	}
+
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
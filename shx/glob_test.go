@@ -0,0 +1,108 @@
+package shx
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlob(t *testing.T) {
+	testcases := []struct {
+		name    string
+		pattern string
+		want    []globMatch
+	}{
+		{name: "no wildcards", pattern: "testdata/copy/a/a1.txt", want: []globMatch{
+			{Path: "testdata/copy/a/a1.txt", Rel: "a1.txt"},
+		}},
+		{name: "single star", pattern: "testdata/copy/a/*.txt", want: []globMatch{
+			{Path: "testdata/copy/a/a1.txt", Rel: "a1.txt"},
+			{Path: "testdata/copy/a/a2.txt", Rel: "a2.txt"},
+		}},
+		{name: "doublestar files", pattern: "testdata/copy/a/**/*.txt", want: []globMatch{
+			{Path: "testdata/copy/a/a1.txt", Rel: "a1.txt"},
+			{Path: "testdata/copy/a/a2.txt", Rel: "a2.txt"},
+			{Path: "testdata/copy/a/ab/ab1.txt", Rel: "ab/ab1.txt"},
+			{Path: "testdata/copy/a/ab/ab2.txt", Rel: "ab/ab2.txt"},
+		}},
+		{name: "doublestar in middle", pattern: "testdata/**/ab/*1.txt", want: []globMatch{
+			{Path: "testdata/copy/a/ab/ab1.txt", Rel: "copy/a/ab/ab1.txt"},
+			{Path: "testdata/copy/partial-dest/ab/ab1.txt", Rel: "copy/partial-dest/ab/ab1.txt"},
+		}},
+		{name: "trailing doublestar", pattern: "testdata/copy/a/**", want: []globMatch{
+			{Path: "testdata/copy/a/a1.txt", Rel: "a1.txt"},
+			{Path: "testdata/copy/a/a2.txt", Rel: "a2.txt"},
+			{Path: "testdata/copy/a/ab", Rel: "ab"},
+			{Path: "testdata/copy/a/ab/ab1.txt", Rel: "ab/ab1.txt"},
+			{Path: "testdata/copy/a/ab/ab2.txt", Rel: "ab/ab2.txt"},
+		}},
+		{name: "missing", pattern: "testdata/missing/**/*.txt", want: nil},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := glob(filepath.FromSlash(tc.pattern))
+			require.NoError(t, err)
+
+			for i := range tc.want {
+				tc.want[i].Path = filepath.FromSlash(tc.want[i].Path)
+				tc.want[i].Rel = filepath.FromSlash(tc.want[i].Rel)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := glob("testdata/**/[")
+		require.Error(t, err)
+	})
+}
+
+func TestMatchPattern(t *testing.T) {
+	testcases := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{pattern: "node_modules", path: "node_modules", isDir: true, want: true},
+		{pattern: "node_modules", path: "web/node_modules", isDir: true, want: true},
+		{pattern: "*.go", path: "pkg/a.go", want: true},
+		{pattern: "*.go", path: "pkg/a.txt", want: false},
+		{pattern: "docs/", path: "docs", isDir: true, want: true},
+		{pattern: "docs/", path: "docs", isDir: false, want: false},
+		{pattern: "/bin", path: "bin", isDir: true, want: true},
+		{pattern: "/bin", path: "cmd/bin", isDir: true, want: false},
+		{pattern: "docs/*.md", path: "docs/a.md", want: true},
+		{pattern: "docs/*.md", path: "web/docs/a.md", want: false},
+		{pattern: "**/testdata", path: "pkg/testdata", isDir: true, want: true},
+		{pattern: "src/**/*.go", path: "src/a/b/c.go", want: true},
+		{pattern: "src/**/*.go", path: "src/c.go", want: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.pattern+" "+tc.path, func(t *testing.T) {
+			got := matchPattern(tc.pattern, tc.path, tc.isDir)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestPathFilter(t *testing.T) {
+	f := pathFilter{
+		Include: []string{"*.txt", "docs/"},
+		Exclude: []string{".git", "testdata"},
+	}
+
+	assert.True(t, f.Excluded(".git/config", false), "files in an excluded directory should be excluded")
+	assert.True(t, f.Excluded("pkg/testdata", true), "nested excluded directory should be excluded")
+	assert.False(t, f.Excluded("pkg/a.txt", false), "unmatched file should not be excluded")
+
+	assert.True(t, f.Included("a/b.txt", false), "file matching an include pattern should be included")
+	assert.True(t, f.Included("docs/index.html", false), "files in an included directory should be included")
+	assert.False(t, f.Included("a/b.go", false), "file not matching an include pattern should not be included")
+
+	assert.True(t, pathFilter{}.Included("a/b.go", false), "all files are included without include patterns")
+}
@@ -0,0 +1,108 @@
+package shx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type MkdirOption int
+
+const (
+	MkdirDefault MkdirOption = iota
+	// MkdirParents creates any missing parent directories, and does not
+	// return an error when the directory already exists.
+	MkdirParents
+	// MkdirDryRun logs what would be created, without creating anything.
+	MkdirDryRun
+)
+
+// MkdirOptions are the set of options that can be passed to MkdirWith.
+type MkdirOptions struct {
+	// Parents creates any missing parent directories, and does not return an
+	// error when the directory already exists.
+	Parents bool
+
+	// Mode is the permissions of created directories, before the umask.
+	// Defaults to 0755.
+	Mode os.FileMode
+
+	// DryRun logs what would be created, without creating anything.
+	DryRun bool
+}
+
+// Mkdir creates a directory with the specified set of MkdirOption.
+// The parent directory may use globbing, which is resolved with
+// filepath.Glob, and ** to match zero or more directories, creating the
+// directory in each matching parent directory, e.g. Mkdir("cmd/*/bin")
+// creates a bin directory in each directory under cmd.
+func Mkdir(path string, opts ...MkdirOption) error {
+	var combinedOpts MkdirOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return MkdirWith(path, MkdirOptions{
+		Parents: combinedOpts&MkdirParents == MkdirParents,
+		DryRun:  combinedOpts&MkdirDryRun == MkdirDryRun,
+	})
+}
+
+// MkdirWith creates a directory with the specified MkdirOptions.
+func MkdirWith(path string, opts MkdirOptions) error {
+	mode := opts.Mode
+	if mode == 0 {
+		mode = 0755
+	}
+
+	dirs, err := expandParent(path)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if opts.Parents {
+			logFileOperation(opts.DryRun, "mkdir -p %s", dir)
+			if opts.DryRun {
+				continue
+			}
+			err = os.MkdirAll(dir, mode)
+		} else {
+			logFileOperation(opts.DryRun, "mkdir %s", dir)
+			if opts.DryRun {
+				continue
+			}
+			err = os.Mkdir(dir, mode)
+		}
+		if err != nil {
+			return fmt.Errorf("could not create directory %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// expandParent resolves a glob in the parent directory of the path, returning
+// the path within each matching parent. The last element of the path is used
+// as-is, so that it may be created.
+func expandParent(path string) ([]string, error) {
+	parent, name := filepath.Split(path)
+	if !hasMeta(filepath.ToSlash(parent)) {
+		return []string{path}, nil
+	}
+
+	parents, err := glob(filepath.Clean(parent))
+	if err != nil {
+		return nil, err
+	}
+	if len(parents) == 0 {
+		return nil, fmt.Errorf("no such file or directory '%s'", parent)
+	}
+
+	paths := make([]string, 0, len(parents))
+	for _, p := range parents {
+		if info, err := os.Stat(p.Path); err == nil && info.IsDir() {
+			paths = append(paths, filepath.Join(p.Path, name))
+		}
+	}
+	return paths, nil
+}
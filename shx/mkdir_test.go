@@ -0,0 +1,74 @@
+package shx
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMkdir(t *testing.T) {
+	t.Run("create directory", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Mkdir(filepath.Join(tmp, "new"))
+		require.NoError(t, err)
+		assert.DirExists(t, filepath.Join(tmp, "new"))
+
+		err = Mkdir(filepath.Join(tmp, "new"))
+		require.Error(t, err, "the directory already exists")
+	})
+
+	t.Run("parents", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Mkdir(filepath.Join(tmp, "a/b/c"))
+		require.Error(t, err, "the parent directories do not exist")
+
+		err = Mkdir(filepath.Join(tmp, "a/b/c"), MkdirParents)
+		require.NoError(t, err)
+		assert.DirExists(t, filepath.Join(tmp, "a/b/c"))
+
+		err = Mkdir(filepath.Join(tmp, "a/b/c"), MkdirParents)
+		require.NoError(t, err, "existing directories should be ignored")
+	})
+
+	t.Run("glob parent", func(t *testing.T) {
+		tmp := copyTestData(t)
+		require.NoError(t, Mkdir(filepath.Join(tmp, "ac")))
+
+		err := Mkdir(filepath.Join(tmp, "a*/bin"))
+		require.NoError(t, err)
+		assert.DirExists(t, filepath.Join(tmp, "ab/bin"))
+		assert.DirExists(t, filepath.Join(tmp, "ac/bin"))
+		assert.NoDirExists(t, filepath.Join(tmp, "a1.txt/bin"), "files should not match the parent directory")
+	})
+
+	t.Run("mode", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("windows does not support unix permissions")
+		}
+		tmp := copyTestData(t)
+
+		err := MkdirWith(filepath.Join(tmp, "private"), MkdirOptions{Mode: 0700})
+		require.NoError(t, err)
+		info, err := os.Stat(filepath.Join(tmp, "private"))
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		stderr := RecordStderr()
+		defer stderr.Release()
+
+		err := Mkdir(filepath.Join(tmp, "new"), MkdirDryRun)
+		require.NoError(t, err)
+		assert.Contains(t, stderr.Output(), "[dry run] mkdir "+filepath.Join(tmp, "new"))
+		assert.NoDirExists(t, filepath.Join(tmp, "new"))
+	})
+}
@@ -1,12 +1,18 @@
 package shx
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
+// rename is os.Rename, and is swapped out in tests to simulate moving between devices.
+var rename = os.Rename
+
 type MoveOption int
 
 const (
@@ -16,10 +22,49 @@ const (
 	MoveRecursive
 )
 
+// MoveOptions are the set of options that can be passed to MoveWith.
+type MoveOptions struct {
+	// NoOverwrite does not overwrite existing files in the destination.
+	NoOverwrite bool
+
+	// Recursive moves the contents of directories.
+	Recursive bool
+
+	// Include limits the moved files to those matching at least one
+	// gitignore-style pattern, such as *.go or docs/. When empty, all files are moved.
+	Include []string
+
+	// Exclude skips files and directories matching any gitignore-style pattern,
+	// such as .git, node_modules or **/testdata.
+	Exclude []string
+}
+
 // Move a file or directory with the specified set of MoveOption.
-// The source may use globbing, which is resolved with filepath.Glob.
+// The source may use globbing, which is resolved with filepath.Glob, and
+// ** to match zero or more directories. When the source uses **, the matched
+// paths are moved into the destination preserving their directory structure
+// relative to the portion of the source without wildcards.
+// When the destination is on a different filesystem, the source is copied to
+// the destination and then removed.
 func Move(src string, dest string, opts ...MoveOption) error {
-	items, err := filepath.Glob(src)
+	var combinedOpts MoveOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return MoveWith(src, dest, MoveOptions{
+		NoOverwrite: combinedOpts&MoveNoOverwrite == MoveNoOverwrite,
+		Recursive:   combinedOpts&MoveRecursive == MoveRecursive,
+	})
+}
+
+// MoveWith moves a file or directory with the specified MoveOptions.
+// Include and exclude patterns are evaluated against the path of each file
+// relative to the destination directory, e.g. moving src/* into dest checks
+// the pattern against paths such as a.go and pkg/b.go. When filtering, files
+// are moved individually and any directories left empty are removed.
+func MoveWith(src string, dest string, opts MoveOptions) error {
+	items, err := glob(src)
 	if err != nil {
 		return err
 	}
@@ -28,18 +73,17 @@ func Move(src string, dest string, opts ...MoveOption) error {
 		return fmt.Errorf("no such file or directory '%s'", src)
 	}
 
-	var combinedOpts MoveOption
-	for _, opt := range opts {
-		combinedOpts |= opt
-	}
-
 	// Check if the destination exists, e.g. if we are moving to /tmp/foo, /tmp should already exist
 	if _, err := os.Stat(filepath.Dir(dest)); err != nil {
 		return err
 	}
 
+	// Moving a directory moves its contents too, so skip anything nested in a matched directory
+	items = withoutNestedMatches(items)
+
+	filter := pathFilter{Include: opts.Include, Exclude: opts.Exclude}
 	for _, item := range items {
-		err := moveFileOrDirectory(item, dest, combinedOpts)
+		err := moveFileOrDirectory(item, dest, opts, filter)
 		if err != nil {
 			return err
 		}
@@ -48,18 +92,82 @@ func Move(src string, dest string, opts ...MoveOption) error {
 	return nil
 }
 
-func moveFileOrDirectory(src string, dest string, opts MoveOption) error {
+func moveFileOrDirectory(item globMatch, dest string, opts MoveOptions, filter pathFilter) error {
 	// If the destination is a directory that exists,
 	// move into the directory.
 	destInfo, err := os.Stat(dest)
 	if err == nil && destInfo.IsDir() {
-		dest = filepath.Join(dest, filepath.Base(src))
+		dest = filepath.Join(dest, item.Rel)
+
+		// Items matched with ** may be nested in directories that aren't in the destination yet
+		if err := mkdirLike(filepath.Dir(item.Path), filepath.Dir(dest)); err != nil {
+			return err
+		}
+	}
+
+	if filter.IsEmpty() {
+		return move(item.Path, dest, opts)
+	}
+	return moveFiltered(item, dest, opts, filter)
+}
+
+// moveFiltered moves the files matching the filter one at a time, removing
+// source directories that are empty once their files are moved.
+func moveFiltered(item globMatch, dest string, opts MoveOptions, filter pathFilter) error {
+	var emptied []string
+	err := filepath.Walk(item.Path, func(srcPath string, srcInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(item.Path, srcPath)
+		if err != nil {
+			return fmt.Errorf("error determining the relative path between %s and %s: %w", item.Path, srcPath, err)
+		}
+
+		filterPath := filepath.Join(item.Rel, relPath)
+		if filter.Excluded(filterPath, srcInfo.IsDir()) {
+			if srcInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if srcInfo.IsDir() || !filter.Included(filterPath, false) {
+			return nil
+		}
+
+		destPath := filepath.Join(dest, relPath)
+		if err := mkdirLike(filepath.Dir(srcPath), filepath.Dir(destPath)); err != nil {
+			return err
+		}
+		if err := move(srcPath, destPath, opts); err != nil {
+			return err
+		}
+
+		if srcPath != item.Path {
+			emptied = append(emptied, filepath.Dir(srcPath))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return move(src, dest, opts)
+	// Remove the deepest directories first so that their parents may become empty too.
+	// os.Remove fails for directories that still have files, which are left in place.
+	sort.Sort(sort.Reverse(sort.StringSlice(emptied)))
+	for _, dir := range emptied {
+		for ; dir != filepath.Dir(item.Path); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
 }
 
-func move(src string, dest string, opts MoveOption) error {
+func move(src string, dest string, opts MoveOptions) error {
 	destExists := true
 	destInfo, err := os.Stat(dest)
 	if err != nil {
@@ -70,7 +178,7 @@ func move(src string, dest string, opts MoveOption) error {
 		}
 	}
 
-	overwrite := opts&MoveNoOverwrite != MoveNoOverwrite
+	overwrite := !opts.NoOverwrite
 	if destExists {
 		if overwrite {
 			// Do not try to rename a file to an existing directory (mimics mv behavior)
@@ -93,5 +201,82 @@ func move(src string, dest string, opts MoveOption) error {
 	}
 
 	log.Printf("%s -> %s\n", src, dest)
-	return os.Rename(src, dest)
+	err = rename(src, dest)
+	if errors.Is(err, errCrossDevice) {
+		// os.Rename can't move between filesystems, e.g. from /tmp to a mounted volume
+		return moveAcrossDevices(src, dest)
+	}
+	return err
+}
+
+// moveAcrossDevices moves a file or directory by copying it to the destination
+// and then removing the source. Each file is copied to a temporary file next to
+// its destination and renamed into place, so that a file in the destination is
+// never partially written. When the copy fails, anything created in the
+// destination is removed and the source is left untouched.
+func moveAcrossDevices(src string, dest string) error {
+	srcInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if !srcInfo.IsDir() {
+		if err := copyPreservingFile(src, dest, srcInfo); err != nil {
+			return err
+		}
+		return os.Remove(src)
+	}
+
+	err = filepath.Walk(src, func(srcPath string, srcInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return fmt.Errorf("error determining the relative path between %s and %s: %w", src, srcPath, err)
+		}
+		destPath := filepath.Join(dest, relPath)
+
+		if srcInfo.IsDir() {
+			if err := os.Mkdir(destPath, srcInfo.Mode().Perm()); err != nil {
+				return err
+			}
+			// Apply the permissions again in case they were limited by the umask
+			return os.Chmod(destPath, srcInfo.Mode().Perm())
+		}
+		return copyPreservingFile(srcPath, destPath, srcInfo)
+	})
+	if err != nil {
+		os.RemoveAll(dest)
+		return fmt.Errorf("error moving %s to %s: %w", src, dest, err)
+	}
+
+	return os.RemoveAll(src)
+}
+
+// copyPreservingFile copies a file or symlink, preserving its permissions and
+// modification time. The file is written to a temporary file in the destination
+// directory, and then renamed to the destination path.
+func copyPreservingFile(src string, dest string, srcInfo os.FileInfo) error {
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dest)
+	}
+
+	srcF, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcF.Close()
+
+	return writeAtomic(dest, srcInfo.Mode().Perm(), srcInfo.ModTime(), func(w io.Writer) error {
+		if _, err := io.Copy(w, srcF); err != nil {
+			return fmt.Errorf("error copying %s to %s: %w", src, dest, err)
+		}
+		return nil
+	})
 }
@@ -16,3 +16,13 @@ func ExampleMove() {
 	// Do not overwrite existing files
 	shx.Move("a/*", "/tmp", shx.MoveNoOverwrite)
 }
+
+func ExampleMoveWith() {
+	// Move the build output into dist, leaving behind debug symbols
+	shx.MoveWith("bin/*", "dist", shx.MoveOptions{
+		Exclude: []string{"*.debug"},
+	})
+
+	// Move all generated files, preserving their directory structure
+	shx.Move("pkg/**/*.gen.go", "/tmp/generated")
+}
@@ -0,0 +1,9 @@
+//go:build !windows
+// +build !windows
+
+package shx
+
+import "syscall"
+
+// errCrossDevice is returned when renaming a file to a different filesystem.
+const errCrossDevice = syscall.EXDEV
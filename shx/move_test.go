@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -172,6 +173,155 @@ func TestMove(t *testing.T) {
 	})
 }
 
+func TestMoveWith(t *testing.T) {
+	t.Run("doublestar preserves directory structure", func(t *testing.T) {
+		defer resetTestdata(t)
+
+		// Make the temp directory on the same physical drive, os.Rename doesn't work across drives and /tmp may be on another drive
+		tmp, err := os.MkdirTemp("testdata", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		err = Move("testdata/copy/a/**/*1.txt", tmp)
+		require.NoError(t, err, "Move with ** failed")
+
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+		assertFile(t, filepath.Join(tmp, "ab/ab1.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a2.txt"))
+		assert.NoFileExists(t, "testdata/copy/a/a1.txt")
+		assert.FileExists(t, "testdata/copy/a/a2.txt")
+	})
+
+	t.Run("exclude", func(t *testing.T) {
+		defer resetTestdata(t)
+
+		// Make the temp directory on the same physical drive, os.Rename doesn't work across drives and /tmp may be on another drive
+		tmp, err := os.MkdirTemp("testdata", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		opts := MoveOptions{
+			Recursive: true,
+			Exclude:   []string{"a2.txt"},
+		}
+		err = MoveWith("testdata/copy/a", tmp, opts)
+		require.NoError(t, err, "Move with exclude failed")
+
+		assertFile(t, filepath.Join(tmp, "a/a1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab2.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a/a2.txt"))
+
+		// Excluded files are left behind, and emptied directories are removed
+		assert.FileExists(t, "testdata/copy/a/a2.txt")
+		assert.NoDirExists(t, "testdata/copy/a/ab")
+	})
+
+	t.Run("include", func(t *testing.T) {
+		defer resetTestdata(t)
+
+		// Make the temp directory on the same physical drive, os.Rename doesn't work across drives and /tmp may be on another drive
+		tmp, err := os.MkdirTemp("testdata", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		opts := MoveOptions{
+			Recursive: true,
+			Include:   []string{"ab/"},
+		}
+		err = MoveWith("testdata/copy/a", tmp, opts)
+		require.NoError(t, err, "Move with include failed")
+
+		assertFile(t, filepath.Join(tmp, "a/ab/ab1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab2.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a/a1.txt"))
+		assert.FileExists(t, "testdata/copy/a/a1.txt")
+	})
+}
+
+func TestMove_CrossDevice(t *testing.T) {
+	// Simulate moving between filesystems, which os.Rename does not support
+	simulateCrossDevice := func() func() {
+		rename = func(src string, dest string) error {
+			return &os.LinkError{Op: "rename", Old: src, New: dest, Err: errCrossDevice}
+		}
+		return func() { rename = os.Rename }
+	}
+
+	t.Run("move directory", func(t *testing.T) {
+		defer resetTestdata(t)
+		defer simulateCrossDevice()()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		require.NoError(t, os.Chmod("testdata/copy/a/a1.txt", 0750))
+
+		err = Move("testdata/copy/a", tmp)
+		require.NoError(t, err, "Move across devices failed")
+
+		assertFile(t, filepath.Join(tmp, "a/a1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/a2.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab1.txt"))
+		assertFile(t, filepath.Join(tmp, "a/ab/ab2.txt"))
+		assert.NoDirExists(t, "testdata/copy/a", "the source should be removed")
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(filepath.Join(tmp, "a/a1.txt"))
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0750), info.Mode().Perm(), "permissions were not preserved")
+		}
+	})
+
+	t.Run("overwrite file", func(t *testing.T) {
+		defer resetTestdata(t)
+		defer simulateCrossDevice()()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "a2.txt")
+		require.NoError(t, os.WriteFile(dest, []byte("a lot of extra data that should be overwritten"), 0600))
+
+		err = Move("testdata/copy/a/a2.txt", dest)
+		require.NoError(t, err, "Move across devices failed")
+
+		assertFile(t, dest)
+		assert.NoFileExists(t, "testdata/copy/a/a2.txt", "the source should be removed")
+
+		leftovers, err := filepath.Glob(filepath.Join(tmp, ".*.tmp*"))
+		require.NoError(t, err)
+		assert.Empty(t, leftovers, "temporary files should be cleaned up")
+	})
+
+	t.Run("cleanup after failure", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("file permissions cannot make a file unreadable on windows")
+		}
+		if os.Geteuid() == 0 {
+			t.Skip("root can read files regardless of their permissions")
+		}
+		defer resetTestdata(t)
+		defer simulateCrossDevice()()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		// Make a file in the directory unreadable so that the copy fails partway through
+		require.NoError(t, os.Chmod("testdata/copy/a/ab/ab2.txt", 0000))
+		defer os.Chmod("testdata/copy/a/ab/ab2.txt", 0644)
+
+		err = Move("testdata/copy/a", tmp)
+		require.Error(t, err, "Move should fail when the source cannot be read")
+
+		assert.NoDirExists(t, filepath.Join(tmp, "a"), "the partial copy should be removed")
+		assertFile(t, "testdata/copy/a/a1.txt")
+	})
+}
+
 func TestMove_MoveNoOverwrite(t *testing.T) {
 	testcases := []struct {
 		name         string
@@ -0,0 +1,10 @@
+//go:build windows
+// +build windows
+
+package shx
+
+import "syscall"
+
+// errCrossDevice is returned when renaming a file to a different drive
+// (ERROR_NOT_SAME_DEVICE).
+const errCrossDevice syscall.Errno = 17
@@ -2,12 +2,19 @@ package shx
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/carolynvs/magex/mgx"
 	"github.com/magefile/mage/mg"
@@ -17,6 +24,41 @@ import (
 type PreparedCommand struct {
 	Cmd         *exec.Cmd
 	StopOnError bool
+
+	beforeHooks []BeforeHook
+	afterHooks  []AfterHook
+	pty         bool
+
+	// stdinFile and stdinCmd are opened when the command is executed
+	stdinFile string
+	stdinCmd  *PreparedCommand
+}
+
+// BeforeHook is called before a command is executed. The hook may modify the
+// command, such as adding arguments or environment variables. When a hook
+// returns an error, the command is not run.
+type BeforeHook func(c *PreparedCommand) error
+
+// AfterHook is called after a command is executed, or failed to run, with the
+// result of the command.
+type AfterHook func(c PreparedCommand, result CommandResult)
+
+// CommandResult is the outcome of executing a PreparedCommand.
+type CommandResult struct {
+	// Ran is true when the command was started.
+	Ran bool
+
+	// ExitCode of the command.
+	ExitCode int
+
+	// Err is the error returned by Exec.
+	Err error
+
+	// Start is when the command was started.
+	Start time.Time
+
+	// Duration is how long the command ran.
+	Duration time.Duration
 }
 
 // Command creates a default command. Stdout is logged in verbose mode. Stderr
@@ -69,16 +111,80 @@ func (c PreparedCommand) CollapseArgs() PreparedCommand {
 }
 
 // Env defines additional environment variables for the command.
-// All ambient environment variables are included by default.
+// All ambient environment variables are included by default. When a variable
+// is already defined, its value is replaced, so the last assignment wins.
+// Variable names are case-insensitive on Windows.
 // Example:
 //  c.Env("X=1", "Y=2")
 func (c PreparedCommand) Env(vars ...string) PreparedCommand {
 	for _, v := range vars {
-		c.Cmd.Env = append(c.Cmd.Env, v)
+		i := indexEnv(c.Cmd.Env, getEnvKey(v))
+		if i >= 0 {
+			c.Cmd.Env[i] = v
+		} else {
+			c.Cmd.Env = append(c.Cmd.Env, v)
+		}
+	}
+	return c
+}
+
+// Unsetenv removes environment variables from the command.
+// Example:
+//  c.Unsetenv("GOFLAGS", "GOOS")
+func (c PreparedCommand) Unsetenv(keys ...string) PreparedCommand {
+	for _, key := range keys {
+		for i := indexEnv(c.Cmd.Env, key); i >= 0; i = indexEnv(c.Cmd.Env, key) {
+			c.Cmd.Env = append(c.Cmd.Env[:i], c.Cmd.Env[i+1:]...)
+		}
+	}
+	return c
+}
+
+// ClearEnv removes all environment variables from the command, so that it
+// does not inherit the ambient environment variables.
+// Example:
+//  c.ClearEnv().InheritEnv("PATH", "HOME").Env("CGO_ENABLED=0")
+func (c PreparedCommand) ClearEnv() PreparedCommand {
+	// An empty, non-nil slice, a nil Env runs the command with the ambient environment
+	c.Cmd.Env = []string{}
+	return c
+}
+
+// InheritEnv copies the specified ambient environment variables to the
+// command, replacing any existing values. Variables that are not set in the
+// current process are ignored. Use with ClearEnv to only pass an allowed set
+// of environment variables to the command.
+func (c PreparedCommand) InheritEnv(keys ...string) PreparedCommand {
+	for _, key := range keys {
+		if value, ok := os.LookupEnv(key); ok {
+			c.Env(key + "=" + value)
+		}
 	}
 	return c
 }
 
+// Getenv returns the value of an environment variable that will be passed to
+// the command, or an empty string when it is not set.
+func (c PreparedCommand) Getenv(key string) string {
+	value, _ := c.LookupEnv(key)
+	return value
+}
+
+// LookupEnv returns the value of an environment variable that will be passed
+// to the command, and if the variable is set.
+func (c PreparedCommand) LookupEnv(key string) (string, bool) {
+	env := c.Cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+
+	i := indexEnv(env, key)
+	if i < 0 {
+		return "", false
+	}
+	return strings.TrimPrefix(env[i][len(getEnvKey(env[i])):], "="), true
+}
+
 // In sets the working directory of the command.
 func (c PreparedCommand) In(dir string) PreparedCommand {
 	c.Cmd.Dir = dir
@@ -88,6 +194,40 @@ func (c PreparedCommand) In(dir string) PreparedCommand {
 // Stdin sets the command's stdin.
 func (c PreparedCommand) Stdin(stdin io.Reader) PreparedCommand {
 	c.Cmd.Stdin = stdin
+	c.stdinFile = ""
+	c.stdinCmd = nil
+	return c
+}
+
+// StdinString sets the command's stdin to the specified string.
+func (c PreparedCommand) StdinString(input string) PreparedCommand {
+	return c.Stdin(strings.NewReader(input))
+}
+
+// StdinFile sets the command's stdin to the contents of a file. The file is
+// opened when the command is executed, and closed when it completes.
+func (c PreparedCommand) StdinFile(path string) PreparedCommand {
+	c = c.Stdin(nil)
+	c.stdinFile = path
+	return c
+}
+
+// StdinFrom sets the command's stdin to the stdout of another command, like
+// a shell pipeline, e.g. StdinFrom(src) is equivalent to "src | cmd". The
+// source command is started when the command is executed. When the source
+// command fails, the command returns its error, even when the command
+// succeeds, like a shell with pipefail set. A copy of the source command is
+// run, so src is not modified and can still be run on its own.
+func (c PreparedCommand) StdinFrom(src PreparedCommand) PreparedCommand {
+	cmd := *src.Cmd
+	cmd.Args = append([]string(nil), src.Cmd.Args...)
+	if src.Cmd.Env != nil {
+		cmd.Env = append([]string(nil), src.Cmd.Env...)
+	}
+	src.Cmd = &cmd
+
+	c = c.Stdin(nil)
+	c.stdinCmd = &src
 	return c
 }
 
@@ -110,29 +250,144 @@ func (c PreparedCommand) Silent() PreparedCommand {
 	return c
 }
 
+// Before registers hooks that are called, in order, before the command is
+// executed.
+func (c PreparedCommand) Before(hooks ...BeforeHook) PreparedCommand {
+	// Copy the hooks so that commands derived from the same command do not share them
+	c.beforeHooks = append(c.beforeHooks[:len(c.beforeHooks):len(c.beforeHooks)], hooks...)
+	return c
+}
+
+// After registers hooks that are called, in order, after the command is
+// executed.
+func (c PreparedCommand) After(hooks ...AfterHook) PreparedCommand {
+	c.afterHooks = append(c.afterHooks[:len(c.afterHooks):len(c.afterHooks)], hooks...)
+	return c
+}
+
 // Exec the prepared command, returning if the command was run and its
 // exit code. Does not modify the configured outputs.
 func (c PreparedCommand) Exec() (ran bool, code int, err error) {
+	// exec applies the before hooks to c, so that changes made by the hooks,
+	// such as setting StopOnError, are used here as well
+	result := c.exec()
+	if result.Err != nil && c.StopOnError {
+		mgx.Must(result.Err)
+	}
+	return result.Ran, result.ExitCode, result.Err
+}
+
+// exec runs the before hooks, which may modify the command, and then the
+// command. The command is traced and the after hooks are called whether or
+// not the command could be run.
+func (c *PreparedCommand) exec() (result CommandResult) {
+	tracer.start(c)
+	defer func() {
+		tracer.record(*c, result)
+		c.runAfterHooks(result)
+	}()
+
+	for _, hook := range c.beforeHooks {
+		if err := hook(c); err != nil {
+			result.Err = fmt.Errorf(`failed to run "%s": %w`, c, err)
+			result.ExitCode = sh.ExitStatus(result.Err)
+			return result
+		}
+	}
+
+	waitForStdin, err := c.openStdin()
+	if err != nil {
+		result.Err = fmt.Errorf(`failed to run "%s": %w`, c, err)
+		result.ExitCode = sh.ExitStatus(result.Err)
+		return result
+	}
+
 	if mg.Verbose() {
 		log.Println("exec:", c.Cmd.Path, strings.Join(c.Cmd.Args, " "))
 	}
 
-	err = c.Cmd.Run()
-	ran = sh.CmdRan(err)
-	code = sh.ExitStatus(err)
+	result.Start = time.Now()
+	err = c.run()
+	result.Duration = time.Since(result.Start)
+	result.Ran = sh.CmdRan(err)
+	result.ExitCode = sh.ExitStatus(err)
 
 	if err != nil {
-		if ran {
-			err = mg.Fatalf(code, `running "%s" failed with exit code %d`, c, code)
+		if errors.Is(err, ErrInterrupted) {
+			result.Err = fmt.Errorf(`running "%s" was stopped: %w`, c, err)
+		} else if result.Ran {
+			result.Err = mg.Fatalf(result.ExitCode, `running "%s" failed with exit code %d`, c, result.ExitCode)
 		} else {
-			err = fmt.Errorf(`failed to run "%s: %v"`, c, err)
+			result.Err = fmt.Errorf(`failed to run "%s: %v"`, c, err)
 		}
-		if c.StopOnError {
-			mgx.Must(err)
+	}
+
+	if stdinResult := waitForStdin(); stdinResult.Err != nil && result.Err == nil {
+		result.ExitCode = stdinResult.ExitCode
+		result.Err = stdinResult.Err
+	}
+
+	return result
+}
+
+// openStdin opens the file or starts the command set with StdinFile or
+// StdinFrom, returning a function that waits for the stdin to be released
+// after the command completes, with the result of the source command.
+func (c PreparedCommand) openStdin() (wait func() CommandResult, err error) {
+	if c.stdinFile != "" {
+		f, err := os.Open(c.stdinFile)
+		if err != nil {
+			return nil, err
+		}
+		c.Cmd.Stdin = f
+		return func() CommandResult {
+			f.Close()
+			return CommandResult{}
+		}, nil
+	}
+
+	if c.stdinCmd != nil {
+		// Connect the commands with a pipe, so that the source command
+		// stops when the command exits without reading all of its output
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
 		}
+
+		src := *c.stdinCmd
+		src.StopOnError = false
+		src.Cmd.Stdout = w
+		srcDone := make(chan CommandResult, 1)
+		go func() {
+			result := src.exec()
+			w.Close()
+			srcDone <- result
+		}()
+
+		c.Cmd.Stdin = r
+		return func() CommandResult {
+			r.Close()
+			return <-srcDone
+		}, nil
 	}
 
-	return ran, code, err
+	return func() CommandResult { return CommandResult{} }, nil
+}
+
+// run the command, tracking the process while it runs, and with a
+// pseudo-terminal when requested.
+func (c PreparedCommand) run() error {
+	if c.pty {
+		return runWithPTY(c.Cmd)
+	}
+	configureProcessGroup(c.Cmd)
+	return runTracked(c.Cmd)
+}
+
+func (c PreparedCommand) runAfterHooks(result CommandResult) {
+	for _, hook := range c.afterHooks {
+		hook(c, result)
+	}
 }
 
 // Run the given command, directing stderr to os.Stderr and
@@ -157,12 +412,13 @@ func (c PreparedCommand) RunV() error {
 
 // RunE is like Run, but it only writes the command combined to os.Stderr when it fails.
 func (c PreparedCommand) RunE() error {
-	output := &bytes.Buffer{}
+	// stdout may be copied from a separate goroutine, e.g. from a pseudo-terminal
+	output := &lockedWriter{w: &bytes.Buffer{}}
 	c.Stdout(output)
 	c.Stderr(output)
 	_, _, err := c.Exec()
 	if err != nil {
-		fmt.Fprint(os.Stderr, output.String())
+		fmt.Fprint(os.Stderr, output.w.String())
 	}
 	return err
 }
@@ -198,15 +454,36 @@ func (c PreparedCommand) OutputV() (string, error) {
 
 // OutputE is like Output, but it only writes the command output to os.Stderr when it fails.
 func (c PreparedCommand) OutputE() (string, error) {
+	stdout, err := c.outputE()
+	return strings.TrimSuffix(stdout.String(), "\n"), err
+}
+
+// outputE executes the command, returning stdout, and writes the combined
+// output to os.Stderr when the command fails.
+func (c PreparedCommand) outputE() (*bytes.Buffer, error) {
+	// stdout and stderr are copied from separate goroutines when they are
+	// different writers, so the combined output must be synchronized.
 	stdout := &bytes.Buffer{}
-	output := &bytes.Buffer{}
+	output := &lockedWriter{w: &bytes.Buffer{}}
 	c.Stdout(io.MultiWriter(stdout, output))
 	c.Stderr(output)
 	_, _, err := c.Exec()
 	if err != nil {
-		fmt.Fprint(os.Stderr, output.String())
+		fmt.Fprint(os.Stderr, output.w.String())
 	}
-	return strings.TrimSuffix(stdout.String(), "\n"), err
+	return stdout, err
+}
+
+// lockedWriter serializes writes to a buffer.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  *bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
 }
 
 // Outputs is like Output, but the command output is not written to stdout/stderr.
@@ -215,3 +492,122 @@ func (c PreparedCommand) OutputS() (string, error) {
 	_, _, err := c.Stdout(stdout).Stderr(nil).Exec()
 	return strings.TrimSuffix(stdout.String(), "\n"), err
 }
+
+// OutputJSON runs the command and decodes its stdout as JSON into v. The
+// command output is only written to os.Stderr when it fails.
+//
+// When v is a pointer to a slice, the output may be a JSON array, or a stream
+// of concatenated JSON values, such as the output of go list -json, which are
+// appended to the slice.
+func (c PreparedCommand) OutputJSON(v interface{}) error {
+	stdout, err := c.outputE()
+	if err != nil {
+		return err
+	}
+
+	if err := decodeJSON(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf(`could not decode the output of "%s" as JSON: %w`, c, err)
+	}
+	return nil
+}
+
+// decodeJSON decodes a JSON value into v, or when v is a pointer to a slice,
+// a stream of JSON values.
+func decodeJSON(data []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer but got %T", v)
+	}
+
+	trimmed := bytes.TrimSpace(data)
+	slice := rv.Elem()
+	if slice.Kind() != reflect.Slice || bytes.HasPrefix(trimmed, []byte("[")) {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		if err := dec.Decode(v); err != nil {
+			return jsonDecodeError(data, dec, err)
+		}
+		if _, err := dec.Token(); err != io.EOF {
+			return fmt.Errorf("unexpected data after the JSON value at offset %d: %s", dec.InputOffset(), outputSnippet(data, dec.InputOffset()))
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		item := reflect.New(slice.Type().Elem())
+		err := dec.Decode(item.Interface())
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return jsonDecodeError(data, dec, err)
+		}
+		slice.Set(reflect.Append(slice, item.Elem()))
+	}
+}
+
+// jsonDecodeError adds the location of the error in the output, and a snippet
+// of the output surrounding the error.
+func jsonDecodeError(data []byte, dec *json.Decoder, err error) error {
+	offset := dec.InputOffset()
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return fmt.Errorf("%w: the output was empty", err)
+	}
+	return fmt.Errorf("%w at offset %d: %s", err, offset, outputSnippet(data, offset))
+}
+
+// outputSnippet returns a short, quoted section of the output around the offset.
+func outputSnippet(data []byte, offset int64) string {
+	const radius = 40
+
+	start, end := offset-radius, offset+radius
+	prefix, suffix := "...", "..."
+	if start <= 0 {
+		start, prefix = 0, ""
+	}
+	if end >= int64(len(data)) {
+		end, suffix = int64(len(data)), ""
+	}
+	if start > end {
+		start = end
+	}
+	return prefix + strconv.Quote(string(data[start:end])) + suffix
+}
+
+// getEnvKey returns the name of the variable in a KEY=VALUE assignment.
+func getEnvKey(assignment string) string {
+	if assignment == "" {
+		return ""
+	}
+
+	// Windows has hidden variables that start with =, e.g. =C:=C:\
+	if i := strings.Index(assignment[1:], "="); i >= 0 {
+		return assignment[:i+1]
+	}
+	return assignment
+}
+
+// indexEnv returns the index of the last assignment to the variable in env, or -1.
+func indexEnv(env []string, key string) int {
+	for i := len(env) - 1; i >= 0; i-- {
+		if envKeyEqual(getEnvKey(env[i]), key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// envKeyEqual compares environment variable names, which are case-insensitive on Windows.
+func envKeyEqual(a string, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
@@ -1,6 +1,7 @@
 package shx_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
 	"path/filepath"
@@ -70,3 +71,49 @@ func ExamplePreparedCommand_CollapseArgs() {
 
 	// Output: hello world
 }
+
+func ExamplePreparedCommand_ClearEnv() {
+	// Build without the ambient environment, only passing through the
+	// variables that the build needs
+	err := shx.Command("go", "build", "./...").
+		ClearEnv().InheritEnv("PATH", "HOME", "GOPATH", "GOCACHE").
+		Env("CGO_ENABLED=0").RunV()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExamplePreparedCommand_OutputJSON() {
+	// go list -json prints a JSON object for each package
+	var pkgs []struct {
+		ImportPath string
+	}
+	err := shx.Command("go", "list", "-json", "github.com/carolynvs/magex/shx").OutputJSON(&pkgs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, pkg := range pkgs {
+		fmt.Println(pkg.ImportPath)
+	}
+	// Output: github.com/carolynvs/magex/shx
+}
+
+func ExamplePreparedCommand_PTY() {
+	// Run the tests in a terminal so that the output is colored
+	output, err := shx.Command("go", "test", "./...").PTY().Output()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(output)
+}
+
+func ExamplePreparedCommand_StdinFrom() {
+	// Equivalent to: go list ./... | grep -v /vendor/
+	list := shx.Command("go", "list", "./...")
+	pkgs, err := shx.Command("grep", "-v", "/vendor/").StdinFrom(list).OutputE()
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(pkgs)
+}
@@ -2,6 +2,7 @@ package shx_test
 
 import (
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -369,3 +370,210 @@ func TestPreparedCommand_Stdin(t *testing.T) {
 
 	assert.Equal(t, "hello world", gotOutput)
 }
+
+func TestPreparedCommand_StdinString(t *testing.T) {
+	gotOutput, err := shx.Command("go", "run", "echo.go", "-").StdinString("hello world").OutputE()
+	require.NoError(t, err, "command failed")
+
+	assert.Equal(t, "hello world", gotOutput)
+}
+
+func TestPreparedCommand_StdinFile(t *testing.T) {
+	t.Run("file exists", func(t *testing.T) {
+		gotOutput, err := shx.Command("go", "run", "echo.go", "-").StdinFile("testdata/copy/a/a1.txt").OutputE()
+		require.NoError(t, err, "command failed")
+
+		assert.Equal(t, "a1.txt", gotOutput)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := shx.Command("go", "run", "echo.go", "-").StdinFile("testdata/missing.txt").OutputE()
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "missing.txt")
+	})
+}
+
+func TestPreparedCommand_StdinFrom(t *testing.T) {
+	t.Run("pipeline", func(t *testing.T) {
+		src := shx.Command("go", "run", "echo.go", "hello world")
+		gotOutput, err := shx.Command("go", "run", "echo.go", "-").StdinFrom(src).OutputE()
+		require.NoError(t, err, "command failed")
+
+		assert.Equal(t, "hello world", gotOutput)
+	})
+
+	t.Run("source fails", func(t *testing.T) {
+		stderr := shx.RecordStderr()
+		defer stderr.Release()
+
+		src := shx.Command("go", "fakecommand").Must()
+		_, code, err := shx.Command("go", "run", "echo.go", "-").StdinFrom(src).Stdout(nil).Exec()
+		require.Error(t, err, "the source command error should be returned")
+		assert.Contains(t, err.Error(), `running "go fakecommand" failed`)
+		assert.Equal(t, 2, code)
+	})
+
+	t.Run("command exits early", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("the test requires a posix shell")
+		}
+
+		// yes writes forever, and should stop when head exits
+		src := shx.Command("yes")
+		gotOutput, err := shx.Command("head", "-n", "1").StdinFrom(src).OutputS()
+		require.Error(t, err, "yes should fail when head stops reading")
+		assert.Equal(t, "y", gotOutput)
+	})
+
+	t.Run("source is not modified", func(t *testing.T) {
+		src := shx.Command("go", "run", "echo.go", "hello world")
+		_, err := shx.Command("go", "run", "echo.go", "-").StdinFrom(src).OutputE()
+		require.NoError(t, err, "pipeline failed")
+
+		gotOutput, err := src.OutputE()
+		require.NoError(t, err, "the source command should still run on its own")
+		assert.Equal(t, "hello world", gotOutput)
+	})
+
+	t.Run("replaced by Stdin", func(t *testing.T) {
+		src := shx.Command("go", "run", "echo.go", "from command")
+		gotOutput, err := shx.Command("go", "run", "echo.go", "-").StdinFrom(src).StdinString("from string").OutputE()
+		require.NoError(t, err, "command failed")
+
+		assert.Equal(t, "from string", gotOutput)
+	})
+}
+
+func TestOutputWithInput(t *testing.T) {
+	gotOutput, err := shx.OutputWithInput("hello world", "go", "run", "echo.go", "-")
+	require.NoError(t, err, "command failed")
+
+	assert.Equal(t, "hello world", gotOutput)
+}
+
+func TestPreparedCommand_Env(t *testing.T) {
+	cmd := shx.Command("go", "version").Env("A=1", "B=2", "A=3")
+	assert.Equal(t, "3", cmd.Getenv("A"), "the last assignment should win")
+	assert.Equal(t, "2", cmd.Getenv("B"))
+
+	var count int
+	for _, v := range cmd.Cmd.Env {
+		if strings.HasPrefix(v, "A=") {
+			count++
+		}
+	}
+	assert.Equal(t, 1, count, "variables should not be duplicated")
+}
+
+func TestPreparedCommand_Unsetenv(t *testing.T) {
+	os.Setenv("MAGEX_TEST_UNSET", "1")
+	defer os.Unsetenv("MAGEX_TEST_UNSET")
+
+	cmd := shx.Command("go", "version").Env("A=1").Unsetenv("A", "MAGEX_TEST_UNSET")
+	_, ok := cmd.LookupEnv("A")
+	assert.False(t, ok, "A should be unset")
+	_, ok = cmd.LookupEnv("MAGEX_TEST_UNSET")
+	assert.False(t, ok, "ambient variables should be unset")
+	assert.NotEmpty(t, cmd.Getenv("PATH"), "other ambient variables should be kept")
+}
+
+func TestPreparedCommand_ClearEnv(t *testing.T) {
+	os.Setenv("MAGEX_TEST_INHERIT", "1")
+	defer os.Unsetenv("MAGEX_TEST_INHERIT")
+
+	cmd := shx.Command("go", "version").ClearEnv()
+	assert.NotNil(t, cmd.Cmd.Env, "a nil environment inherits the ambient environment")
+	assert.Empty(t, cmd.Cmd.Env)
+
+	cmd.InheritEnv("MAGEX_TEST_INHERIT", "MAGEX_TEST_MISSING").Env("B=2")
+	assert.Equal(t, []string{"MAGEX_TEST_INHERIT=1", "B=2"}, cmd.Cmd.Env)
+}
+
+func TestPreparedCommand_Getenv(t *testing.T) {
+	cmd := shx.Command("go", "version").Env("EMPTY=", "EQUALS=a=b")
+
+	value, ok := cmd.LookupEnv("EMPTY")
+	assert.True(t, ok, "EMPTY should be set")
+	assert.Empty(t, value)
+
+	assert.Equal(t, "a=b", cmd.Getenv("EQUALS"))
+	assert.Empty(t, cmd.Getenv("MAGEX_TEST_MISSING"))
+}
+
+func TestPreparedCommand_OutputJSON(t *testing.T) {
+	type item struct {
+		Name string
+	}
+
+	t.Run("single value", func(t *testing.T) {
+		var got item
+		err := shx.Command("go", "run", "echo.go", `{"name": "a"}`).OutputJSON(&got)
+		require.NoError(t, err)
+		assert.Equal(t, item{Name: "a"}, got)
+	})
+
+	t.Run("array", func(t *testing.T) {
+		var got []item
+		err := shx.Command("go", "run", "echo.go", `[{"name": "a"}, {"name": "b"}]`).OutputJSON(&got)
+		require.NoError(t, err)
+		assert.Equal(t, []item{{Name: "a"}, {Name: "b"}}, got)
+	})
+
+	t.Run("concatenated values", func(t *testing.T) {
+		var got []item
+		err := shx.Command("go", "run", "echo.go", "{\"name\": \"a\"}\n{\"name\": \"b\"}").OutputJSON(&got)
+		require.NoError(t, err)
+		assert.Equal(t, []item{{Name: "a"}, {Name: "b"}}, got)
+	})
+
+	t.Run("go list -json", func(t *testing.T) {
+		var got []struct {
+			ImportPath string
+		}
+		err := shx.Command("go", "list", "-json", "github.com/carolynvs/magex/mgx", "github.com/carolynvs/magex/xplat").OutputJSON(&got)
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, "github.com/carolynvs/magex/mgx", got[0].ImportPath)
+		assert.Equal(t, "github.com/carolynvs/magex/xplat", got[1].ImportPath)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		var got item
+		err := shx.Command("go", "run", "echo.go", `{"name": oops}`).OutputJSON(&got)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `could not decode the output of "go run echo.go {"name": oops}" as JSON`)
+		assert.Contains(t, err.Error(), `"{\"name\": oops}\n"`, "the error should include the output")
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		var got item
+		err := shx.Command("go", "run", "echo.go", `{"name": 1}`).OutputJSON(&got)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "cannot unmarshal number")
+	})
+
+	t.Run("multiple values into a single value", func(t *testing.T) {
+		var got item
+		err := shx.Command("go", "run", "echo.go", `{"name": "a"}{"name": "b"}`).OutputJSON(&got)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unexpected data after the JSON value")
+	})
+
+	t.Run("empty output", func(t *testing.T) {
+		var got item
+		err := shx.Command("go", "run", "echo.go").OutputJSON(&got)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "the output was empty")
+	})
+
+	t.Run("command fails", func(t *testing.T) {
+		stderr := shx.RecordStderr()
+		defer stderr.Release()
+
+		var got item
+		err := shx.Command("go", "fakecommand").OutputJSON(&got)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "exit code 2")
+		assert.Contains(t, stderr.Output(), "go fakecommand", "the command output should be written to stderr when it fails")
+	})
+}
@@ -0,0 +1,189 @@
+package shx
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+// ErrInterrupted is returned, wrapped, by commands that are stopped because
+// the current process received SIGINT or SIGTERM while they were running.
+// The current process is not exited, so the magefile decides how to handle
+// the interruption, such as returning the error from the target.
+var ErrInterrupted = errors.New("interrupted")
+
+// processShutdownTimeout is how long to wait for commands to exit after the
+// current process is interrupted, before they are killed.
+var processShutdownTimeout = 5 * time.Second
+
+// processes tracks the commands that are running, so that they are stopped,
+// instead of being orphaned, when the current process is interrupted.
+//
+// While commands are running, SIGINT and SIGTERM are forwarded to the
+// commands. On Unix, commands run in their own process group when the current
+// process is not in the foreground of a terminal, so that the signal reaches
+// any processes that they started as well. Commands that do not exit in time
+// are killed.
+var processes = &processTracker{procs: make(map[*os.Process]os.Signal)}
+
+type processTracker struct {
+	mu sync.Mutex
+
+	// procs are the running processes, and the signal that stopped them,
+	// when they were stopped by the tracker.
+	procs   map[*os.Process]os.Signal
+	signals chan os.Signal
+
+	// stopping is the signal that is being handled, while the running
+	// processes are stopped.
+	stopping os.Signal
+}
+
+// interruptedError is returned by a command that was stopped because the
+// current process received a signal.
+type interruptedError struct {
+	sig os.Signal
+}
+
+func (e interruptedError) Error() string {
+	return fmt.Sprintf("%s by signal: %s", ErrInterrupted, e.sig)
+}
+
+func (e interruptedError) Is(target error) bool {
+	return target == ErrInterrupted
+}
+
+// ExitStatus follows the shell convention for a command that exited because
+// of a signal, which is used by sh.ExitStatus.
+func (e interruptedError) ExitStatus() int {
+	if s, ok := e.sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// runTracked starts the command and waits for it to complete, tracking the
+// process while it runs.
+func runTracked(cmd *exec.Cmd) error {
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	processes.add(cmd.Process)
+	return processes.remove(cmd.Process, cmd.Wait())
+}
+
+// add starts tracking a running process.
+func (t *processTracker) add(p *os.Process) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.procs[p] = nil
+	if t.stopping != nil {
+		// The running commands are being stopped, stop commands that are started in the meantime
+		t.procs[p] = t.stopping
+		signalProcess(p, os.Kill)
+	}
+
+	// Only handle signals while commands are running, so that the default
+	// behavior, or a handler registered by the magefile, is used otherwise.
+	if len(t.procs) == 1 && t.signals == nil {
+		t.signals = make(chan os.Signal, 1)
+		signal.Notify(t.signals, shutdownSignals...)
+		go t.handleSignals(t.signals)
+	}
+}
+
+// remove stops tracking a process after it has exited, returning the error
+// from waiting for the process, or an error wrapping ErrInterrupted when the
+// process failed after it was stopped because the current process was
+// interrupted.
+func (t *processTracker) remove(p *os.Process, err error) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	sig := t.procs[p]
+	delete(t.procs, p)
+	if len(t.procs) == 0 {
+		// Restore the default handling of the signals, or the magefile's handlers
+		if t.signals != nil {
+			signal.Stop(t.signals)
+			close(t.signals)
+			t.signals = nil
+		}
+		t.stopping = nil
+	}
+
+	if sig != nil && err != nil {
+		return interruptedError{sig: sig}
+	}
+	return err
+}
+
+// count returns the number of running processes.
+func (t *processTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.procs)
+}
+
+func (t *processTracker) handleSignals(signals chan os.Signal) {
+	sig, ok := <-signals
+	if !ok {
+		return
+	}
+	t.shutdown(sig)
+}
+
+// shutdown forwards the signal to the running processes, and kills them if
+// they do not exit in time. The current process is not exited, instead the
+// commands return an error wrapping ErrInterrupted. Commands that are started
+// before the running processes exit are killed as well.
+func (t *processTracker) shutdown(sig os.Signal) {
+	t.mu.Lock()
+	t.stopping = sig
+	procs := make([]*os.Process, 0, len(t.procs))
+	for p := range t.procs {
+		t.procs[p] = sig
+		procs = append(procs, p)
+	}
+	t.mu.Unlock()
+
+	if mg.Verbose() {
+		log.Printf("received %s, stopping %d running command(s)\n", sig, len(procs))
+	}
+	for _, p := range procs {
+		forwardSignal(p, sig)
+	}
+
+	if !t.waitForExit(processShutdownTimeout) {
+		t.mu.Lock()
+		for p := range t.procs {
+			if mg.Verbose() {
+				log.Printf("killing process %d because it did not exit after %s\n", p.Pid, processShutdownTimeout)
+			}
+			signalProcess(p, os.Kill)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// waitForExit waits for the running processes to exit and be removed, which
+// happens after they are reaped, returning false when the timeout expires.
+func (t *processTracker) waitForExit(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for t.count() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+//go:build !windows
+// +build !windows
+
+package shx
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// shutdownSignals are forwarded to the running commands.
+var shutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// configureProcessGroup runs the command in its own process group, so that
+// signals can be forwarded to the command and any processes that it starts.
+// This is not needed when the current process is in the foreground of a
+// terminal, because the terminal sends Ctrl-C to every process in the
+// foreground process group, and a command in another process group is stopped
+// when it reads from the terminal. Commands that read from a terminal stay in
+// the current process group for the same reason.
+func configureProcessGroup(cmd *exec.Cmd) {
+	if isForegroundProcess() {
+		return
+	}
+	if stdin, ok := cmd.Stdin.(*os.File); ok {
+		if info, err := stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return
+		}
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// forwardSignal sends a signal received by the current process to the command.
+// Commands in the current process group already received Ctrl-C from the
+// terminal, and are not sent SIGINT again, because some commands exit
+// immediately, without cleaning up, when they are interrupted twice.
+func forwardSignal(p *os.Process, sig os.Signal) error {
+	if sig == os.Interrupt && !leadsProcessGroup(p) && isForegroundProcess() {
+		return nil
+	}
+	return signalProcess(p, sig)
+}
+
+// signalProcess sends the signal to the process group led by the process, or
+// only the process when it does not lead a process group.
+func signalProcess(p *os.Process, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok {
+		return p.Signal(sig)
+	}
+
+	if leadsProcessGroup(p) {
+		return syscall.Kill(-p.Pid, s)
+	}
+	return p.Signal(sig)
+}
+
+func leadsProcessGroup(p *os.Process) bool {
+	pgid, err := syscall.Getpgid(p.Pid)
+	return err == nil && pgid == p.Pid
+}
@@ -0,0 +1,114 @@
+//go:build !windows
+// +build !windows
+
+package shx
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// interruptCommand runs the command, and then sends the signal to the current
+// process, returning the result of the command.
+func interruptCommand(t *testing.T, cmd PreparedCommand, sig syscall.Signal) (code int, err error) {
+	type result struct {
+		code int
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		_, code, err := cmd.Exec()
+		done <- result{code, err}
+	}()
+
+	// Wait for the command to start
+	require.Eventually(t, func() bool { return processes.count() == 1 }, 10*time.Second, 10*time.Millisecond)
+	require.NoError(t, syscall.Kill(os.Getpid(), sig))
+
+	select {
+	case r := <-done:
+		return r.code, r.err
+	case <-time.After(processShutdownTimeout + 5*time.Second):
+		t.Fatal("the command was not stopped")
+	}
+	return 0, nil
+}
+
+func TestProcessCleanup(t *testing.T) {
+	t.Run("forwards signal to the process group", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+		marker := filepath.Join(tmp, "child-pid")
+
+		if isForegroundProcess() {
+			t.Skip("commands are not run in their own process group in the foreground of a terminal")
+		}
+
+		// The shell starts a child process that would be orphaned if only the shell was stopped
+		cmd := Command("sh", "-c", "sleep 30 & echo $! > "+marker+"; wait").Stdout(nil)
+		start := time.Now()
+		code, err := interruptCommand(t, cmd, syscall.SIGTERM)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrInterrupted), "the command should return an interrupted error, got %v", err)
+		assert.Equal(t, 128+int(syscall.SIGTERM), code)
+		assert.Less(t, int64(time.Since(start)), int64(processShutdownTimeout), "the command should exit without being killed")
+
+		contents, err := ioutil.ReadFile(marker)
+		require.NoError(t, err)
+		var childPid int
+		_, err = fmt.Sscan(string(contents), &childPid)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return syscall.Kill(childPid, 0) == syscall.ESRCH
+		}, 5*time.Second, 10*time.Millisecond, "the child process should be stopped")
+		assert.Equal(t, 0, processes.count())
+	})
+
+	t.Run("kills commands that do not exit", func(t *testing.T) {
+		defer func(timeout time.Duration) { processShutdownTimeout = timeout }(processShutdownTimeout)
+		processShutdownTimeout = 500 * time.Millisecond
+
+		// Ignore SIGINT so that the command must be killed
+		cmd := Command("sh", "-c", "trap '' INT; sleep 30").Stdout(nil)
+		start := time.Now()
+		_, err := interruptCommand(t, cmd, syscall.SIGINT)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrInterrupted), "the command should return an interrupted error, got %v", err)
+		assert.GreaterOrEqual(t, int64(time.Since(start)), int64(processShutdownTimeout), "the command should be killed after the timeout")
+	})
+
+	t.Run("signal handlers are called", func(t *testing.T) {
+		handled := make(chan os.Signal, 1)
+		signal.Notify(handled, syscall.SIGTERM)
+		defer signal.Stop(handled)
+
+		cmd := Command("sleep", "30").Stdout(nil)
+		_, err := interruptCommand(t, cmd, syscall.SIGTERM)
+		require.Error(t, err)
+
+		select {
+		case sig := <-handled:
+			assert.Equal(t, syscall.SIGTERM, sig)
+		case <-time.After(5 * time.Second):
+			t.Fatal("the signal was not sent to the handler registered by the magefile")
+		}
+	})
+
+	t.Run("signals are only handled while commands are running", func(t *testing.T) {
+		require.NoError(t, Command("go", "version").RunS())
+		processes.mu.Lock()
+		defer processes.mu.Unlock()
+		assert.Nil(t, processes.signals)
+	})
+}
@@ -0,0 +1,31 @@
+//go:build windows
+// +build windows
+
+package shx
+
+import (
+	"os"
+	"os/exec"
+)
+
+// shutdownSignals are forwarded to the running commands.
+var shutdownSignals = []os.Signal{os.Interrupt}
+
+// configureProcessGroup does nothing on Windows, where Ctrl-C is already sent
+// to every process attached to the console.
+func configureProcessGroup(cmd *exec.Cmd) {}
+
+// signalProcess kills the process. Windows does not support sending other
+// signals to a process, and the process already received Ctrl-C from the console.
+func signalProcess(p *os.Process, sig os.Signal) error {
+	if sig == os.Kill {
+		return p.Kill()
+	}
+	return nil
+}
+
+// forwardSignal does nothing unless the process is killed, because Ctrl-C is
+// already sent to every process attached to the console.
+func forwardSignal(p *os.Process, sig os.Signal) error {
+	return signalProcess(p, sig)
+}
@@ -0,0 +1,30 @@
+package shx
+
+// PTY runs the command attached to a pseudo-terminal, so that commands that
+// detect a terminal, such as docker login or test runners with colored
+// output, behave as if they were run interactively.
+//
+// The command's stdin and stdout are connected to the terminal, and its output
+// is still written to the configured stdout, so it can be captured with
+// Output. Stderr is not connected to the terminal, so that it can be captured
+// separately. When stdin is not set and the current process is running in a
+// terminal, the command reads from the current terminal, and the size of the
+// current terminal is forwarded to the command when it changes.
+//
+// Pseudo-terminals are supported on Linux and macOS. When a pseudo-terminal
+// cannot be allocated, such as on Windows, the command is run normally.
+func (c PreparedCommand) PTY() PreparedCommand {
+	c.pty = true
+	return c
+}
+
+// defaultTerminalSize is used when the current process is not running in a terminal.
+var defaultTerminalSize = winsize{Rows: 24, Cols: 80}
+
+// winsize is the size of a terminal in characters.
+type winsize struct {
+	Rows   uint16
+	Cols   uint16
+	Xpixel uint16
+	Ypixel uint16
+}
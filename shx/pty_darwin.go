@@ -0,0 +1,50 @@
+//go:build darwin
+// +build darwin
+
+package shx
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	ioctlGetTermios = syscall.TIOCGETA
+	ioctlSetTermios = syscall.TIOCSETA
+)
+
+// openPTY allocates a pseudo-terminal, returning the master and slave devices.
+func openPTY() (master *os.File, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := ioctl(master.Fd(), syscall.TIOCPTYGRANT, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("could not grant access to the pseudo-terminal: %w", err)
+	}
+	if err := ioctl(master.Fd(), syscall.TIOCPTYUNLK, 0); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("could not unlock the pseudo-terminal: %w", err)
+	}
+
+	name := make([]byte, 128)
+	if err := ioctl(master.Fd(), syscall.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("could not determine the pseudo-terminal name: %w", err)
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	slave, err = os.OpenFile(string(name), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
@@ -0,0 +1,27 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+package shx
+
+import (
+	"log"
+	"os/exec"
+
+	"github.com/magefile/mage/mg"
+)
+
+// runWithPTY runs the command normally, because pseudo-terminals are not
+// supported on this platform.
+func runWithPTY(cmd *exec.Cmd) error {
+	if mg.Verbose() {
+		log.Println("pseudo-terminals are not supported on this platform, running without a terminal")
+	}
+	return runTracked(cmd)
+}
+
+// isForegroundProcess returns false, because the terminal of the current
+// process is not checked on this platform, so that commands are run in their
+// own process group where it is supported.
+func isForegroundProcess() bool {
+	return false
+}
@@ -0,0 +1,97 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package shx
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreparedCommand_PTY(t *testing.T) {
+	t.Run("stdout is a terminal", func(t *testing.T) {
+		// test -t exits with 0 when the file descriptor is a terminal
+		err := Command("sh", "-c", "test -t 0 && test -t 1").PTY().RunS()
+		require.NoError(t, err, "stdin and stdout should be a terminal")
+
+		err = Command("sh", "-c", "test -t 1").RunS()
+		require.Error(t, err, "stdout should not be a terminal without PTY")
+	})
+
+	t.Run("capture output", func(t *testing.T) {
+		output, err := Command("sh", "-c", "echo hello; echo world").PTY().OutputS()
+		require.NoError(t, err)
+		assert.Equal(t, "hello\nworld", output, "the output should not have \\r\\n line endings")
+	})
+
+	t.Run("stderr is captured separately", func(t *testing.T) {
+		var stderr strings.Builder
+		output, err := Command("sh", "-c", "echo out; echo err >&2; test ! -t 2").PTY().Stderr(&stderr).Output()
+		require.NoError(t, err, "stderr should not be a terminal")
+		assert.Equal(t, "out", output)
+		assert.Equal(t, "err\n", stderr.String())
+	})
+
+	t.Run("terminal size", func(t *testing.T) {
+		output, err := Command("stty", "size").PTY().OutputS()
+		require.NoError(t, err)
+		// This test may run in a terminal, so only check that a size is set
+		assert.NotEqual(t, "0 0", output)
+	})
+
+	t.Run("stdin", func(t *testing.T) {
+		output, err := Command("sh", "-c", "read line; echo got $line").PTY().Stdin(strings.NewReader("hello\n")).OutputS()
+		require.NoError(t, err)
+		// The terminal echos the input
+		assert.Contains(t, output, "got hello")
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		ran, code, err := Command("sh", "-c", "exit 3").PTY().Stdout(nil).Exec()
+		require.Error(t, err)
+		assert.True(t, ran)
+		assert.Equal(t, 3, code)
+	})
+}
+
+func TestDefaultTerminalSize(t *testing.T) {
+	if _, isTerm := currentTerminal(); isTerm {
+		t.Skip("the test is running in a terminal")
+	}
+
+	output, err := Command("stty", "size").PTY().OutputS()
+	require.NoError(t, err)
+	assert.Equal(t, "24 80", output)
+}
+
+func TestForwardTerminalInput_RestoresBlockingMode(t *testing.T) {
+	for _, nonblock := range []bool{false, true} {
+		t.Run(fmt.Sprintf("nonblock=%t", nonblock), func(t *testing.T) {
+			master, slave, err := openPTY()
+			require.NoError(t, err, "could not allocate a pseudo-terminal")
+			defer master.Close()
+			defer slave.Close()
+
+			// Use the terminal as stdin
+			origStdin := os.Stdin
+			os.Stdin = slave
+			defer func() { os.Stdin = origStdin }()
+
+			fd := int(slave.Fd())
+			require.NoError(t, syscall.SetNonblock(fd, nonblock))
+
+			stop := forwardTerminalInput(master)
+			stop()
+
+			got, err := isNonblock(fd)
+			require.NoError(t, err)
+			assert.Equal(t, nonblock, got, "the blocking mode of stdin should be restored")
+		})
+	}
+}
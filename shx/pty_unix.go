@@ -0,0 +1,258 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package shx
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/magefile/mage/mg"
+)
+
+// runWithPTY runs the command with its stdin and stdout attached to a
+// pseudo-terminal, falling back to running the command normally when one
+// cannot be allocated.
+func runWithPTY(cmd *exec.Cmd) error {
+	master, slave, err := openPTY()
+	if err != nil {
+		if mg.Verbose() {
+			log.Printf("could not allocate a pseudo-terminal, running without a terminal: %s\n", err)
+		}
+		return runTracked(cmd)
+	}
+	defer master.Close()
+
+	// Leave line endings alone, so that captured output does not contain \r\n
+	if err := disableOutputCRLF(slave); err != nil {
+		slave.Close()
+		return err
+	}
+
+	size := defaultTerminalSize
+	termFd, isTerm := currentTerminal()
+	if isTerm {
+		if current, err := getWinsize(termFd); err == nil {
+			size = current
+		}
+	}
+	if err := setWinsize(master.Fd(), size); err != nil {
+		slave.Close()
+		return err
+	}
+
+	stdin, stdout := cmd.Stdin, cmd.Stdout
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+	cmd.Stdin = slave
+	cmd.Stdout = slave
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// Start a new session with the terminal as its controlling terminal
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	cmd.SysProcAttr.Ctty = 0
+
+	err = cmd.Start()
+	slave.Close()
+	if err != nil {
+		return err
+	}
+	processes.add(cmd.Process)
+
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		// Reading from the terminal fails with EIO after the command exits
+		io.Copy(stdout, master)
+	}()
+
+	if stdin != nil {
+		go func() {
+			io.Copy(master, stdin)
+			// Send end of file (Ctrl-D)
+			master.Write([]byte{4})
+		}()
+	} else if isTerm {
+		stopInput := forwardTerminalInput(master)
+		defer stopInput()
+	}
+
+	if isTerm {
+		stopResize := forwardTerminalSize(termFd, master)
+		defer stopResize()
+	}
+
+	err = cmd.Wait()
+	<-outputDone
+	return processes.remove(cmd.Process, err)
+}
+
+// currentTerminal returns the file descriptor of the terminal that the
+// current process is running in, if any.
+func currentTerminal() (uintptr, bool) {
+	for _, f := range []*os.File{os.Stdin, os.Stdout} {
+		if fd, ok := rawFd(f); ok && isTerminal(fd) {
+			return fd, true
+		}
+	}
+	return 0, false
+}
+
+// forwardTerminalInput copies the input of the current terminal to the
+// pseudo-terminal, with the current terminal in raw mode so that keys are sent
+// as they are pressed, and returns a function that stops forwarding.
+func forwardTerminalInput(master *os.File) (stop func()) {
+	stdinFd, ok := rawFd(os.Stdin)
+	if !ok || !isTerminal(stdinFd) {
+		return func() {}
+	}
+	fd := int(stdinFd)
+
+	restore, err := makeInputRaw(uintptr(fd))
+	if err != nil {
+		return func() {}
+	}
+
+	// Read from a non-blocking copy of stdin, so that the read can be
+	// cancelled when the command exits, without consuming the next input.
+	// The copy shares its blocking mode with stdin, and with other processes
+	// using the terminal, so the original mode is restored afterwards.
+	nonblock, err := isNonblock(fd)
+	if err != nil {
+		restore()
+		return func() {}
+	}
+	inFd, err := syscall.Dup(fd)
+	if err != nil {
+		restore()
+		return func() {}
+	}
+	syscall.SetNonblock(inFd, true)
+	in := os.NewFile(uintptr(inFd), "stdin")
+	go io.Copy(master, in)
+
+	return func() {
+		in.SetReadDeadline(time.Now())
+		in.Close()
+		syscall.SetNonblock(fd, nonblock)
+		restore()
+	}
+}
+
+// isNonblock returns if the file descriptor is in non-blocking mode.
+func isNonblock(fd int) (bool, error) {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return false, errno
+	}
+	return flags&syscall.O_NONBLOCK != 0, nil
+}
+
+// forwardTerminalSize updates the size of the pseudo-terminal when the size
+// of the current terminal changes, and returns a function that stops forwarding.
+func forwardTerminalSize(termFd uintptr, master *os.File) (stop func()) {
+	resized := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(resized, syscall.SIGWINCH)
+	go func() {
+		for {
+			select {
+			case <-resized:
+				if size, err := getWinsize(termFd); err == nil {
+					setWinsize(master.Fd(), size)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(resized)
+		close(done)
+	}
+}
+
+func isTerminal(fd uintptr) bool {
+	var termios syscall.Termios
+	return ioctl(fd, ioctlGetTermios, uintptr(unsafe.Pointer(&termios))) == nil
+}
+
+// makeInputRaw disables line buffering, echo and signal characters for the
+// terminal's input, leaving its output processing alone, and returns a
+// function that restores the previous settings.
+func makeInputRaw(fd uintptr) (restore func(), err error) {
+	var orig syscall.Termios
+	if err := ioctl(fd, ioctlGetTermios, uintptr(unsafe.Pointer(&orig))); err != nil {
+		return nil, err
+	}
+
+	raw := orig
+	raw.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP | syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	raw.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	raw.Cc[syscall.VMIN] = 1
+	raw.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, ioctlSetTermios, uintptr(unsafe.Pointer(&raw))); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ioctl(fd, ioctlSetTermios, uintptr(unsafe.Pointer(&orig)))
+	}, nil
+}
+
+// disableOutputCRLF stops the terminal from translating \n to \r\n in the
+// command's output.
+func disableOutputCRLF(tty *os.File) error {
+	var termios syscall.Termios
+	if err := ioctl(tty.Fd(), ioctlGetTermios, uintptr(unsafe.Pointer(&termios))); err != nil {
+		return err
+	}
+	termios.Oflag &^= syscall.ONLCR
+	return ioctl(tty.Fd(), ioctlSetTermios, uintptr(unsafe.Pointer(&termios)))
+}
+
+func getWinsize(fd uintptr) (winsize, error) {
+	var size winsize
+	err := ioctl(fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	return size, err
+}
+
+func setWinsize(fd uintptr, size winsize) error {
+	return ioctl(fd, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+func ioctl(fd uintptr, request uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isForegroundProcess returns if the current process is in the foreground
+// process group of its controlling terminal, so that it receives Ctrl-C from
+// the terminal.
+func isForegroundProcess() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	defer tty.Close()
+
+	var pgid int32
+	if err := ioctl(tty.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgid))); err != nil {
+		return false
+	}
+	return int(pgid) == syscall.Getpgrp()
+}
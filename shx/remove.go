@@ -0,0 +1,85 @@
+package shx
+
+import (
+	"fmt"
+	"os"
+)
+
+type RemoveOption int
+
+const (
+	RemoveDefault RemoveOption = iota
+	// RemoveRecursive removes directories and their contents.
+	RemoveRecursive
+	// RemoveDryRun logs what would be removed, without removing anything.
+	RemoveDryRun
+)
+
+// RemoveOptions are the set of options that can be passed to RemoveWith.
+type RemoveOptions struct {
+	// Recursive removes directories and their contents. Otherwise only empty
+	// directories are removed.
+	Recursive bool
+
+	// DryRun logs what would be removed, without removing anything.
+	DryRun bool
+
+	// MustExist returns an error when the pattern does not match any files.
+	MustExist bool
+}
+
+// Remove files or directories with the specified set of RemoveOption.
+// The pattern may use globbing, which is resolved with filepath.Glob, and
+// ** to match zero or more directories. Like rm -f, it is not an error when
+// the pattern does not match any files.
+func Remove(pattern string, opts ...RemoveOption) error {
+	var combinedOpts RemoveOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return RemoveWith(pattern, RemoveOptions{
+		Recursive: combinedOpts&RemoveRecursive == RemoveRecursive,
+		DryRun:    combinedOpts&RemoveDryRun == RemoveDryRun,
+	})
+}
+
+// RemoveWith removes files or directories with the specified RemoveOptions.
+func RemoveWith(pattern string, opts RemoveOptions) error {
+	items, err := glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	if len(items) == 0 && opts.MustExist {
+		return fmt.Errorf("no such file or directory '%s'", pattern)
+	}
+
+	// Don't remove a path twice when both it and its parent directory matched
+	if opts.Recursive {
+		items = withoutNestedMatches(items)
+	}
+
+	// Remove children before their parents, so that directories matched with
+	// ** are empty by the time that they are removed
+	for i := len(items) - 1; i >= 0; i-- {
+		path := items[i].Path
+		if opts.Recursive {
+			logFileOperation(opts.DryRun, "rm -r %s", path)
+			if opts.DryRun {
+				continue
+			}
+			err = os.RemoveAll(path)
+		} else {
+			logFileOperation(opts.DryRun, "rm %s", path)
+			if opts.DryRun {
+				continue
+			}
+			err = os.Remove(path)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not remove %s: %w", path, err)
+		}
+	}
+	return nil
+}
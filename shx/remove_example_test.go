@@ -0,0 +1,34 @@
+package shx_test
+
+import (
+	"github.com/carolynvs/magex/shx"
+)
+
+func ExampleRemove() {
+	// Remove the build output
+	shx.Remove("bin", shx.RemoveRecursive)
+
+	// Remove generated files in any directory
+	shx.Remove("**/*.gen.go")
+
+	// Log what would be removed, without removing anything
+	shx.Remove("dist/*", shx.RemoveRecursive, shx.RemoveDryRun)
+}
+
+func ExampleMkdir() {
+	// Create a directory and any missing parent directories
+	shx.Mkdir("bin/linux-amd64", shx.MkdirParents)
+
+	// Create a bin directory for each command
+	shx.Mkdir("cmd/*/bin")
+}
+
+func ExampleTouch() {
+	// Mark a target as complete
+	shx.Touch(".magex/setup.done")
+}
+
+func ExampleChmod() {
+	// Make the scripts executable
+	shx.Chmod("scripts/*.sh", 0755)
+}
@@ -0,0 +1,97 @@
+package shx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyTestData copies testdata/copy/a into a temporary directory, which is
+// removed when the test completes.
+func copyTestData(t *testing.T) string {
+	t.Helper()
+
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+
+	require.NoError(t, Copy("testdata/copy/a/*", tmp, CopyRecursive))
+	return tmp
+}
+
+func TestRemove(t *testing.T) {
+	t.Run("glob", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Remove(filepath.Join(tmp, "a*.txt"))
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(tmp, "a1.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "a2.txt"))
+		assert.DirExists(t, filepath.Join(tmp, "ab"))
+	})
+
+	t.Run("doublestar", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Remove(filepath.Join(tmp, "**/*2.txt"))
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(tmp, "a2.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "ab/ab2.txt"))
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+		assertFile(t, filepath.Join(tmp, "ab/ab1.txt"))
+	})
+
+	t.Run("directory requires recursive", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Remove(filepath.Join(tmp, "ab"))
+		require.Error(t, err, "a directory with files should not be removed")
+		assert.DirExists(t, filepath.Join(tmp, "ab"))
+
+		err = Remove(filepath.Join(tmp, "ab"), RemoveRecursive)
+		require.NoError(t, err)
+		assert.NoDirExists(t, filepath.Join(tmp, "ab"))
+	})
+
+	t.Run("empty directories", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, "ab/sub.txt"), nil, 0644))
+		require.NoError(t, os.Mkdir(filepath.Join(tmp, "ab/sub"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, "ab/sub/file.txt"), nil, 0644))
+
+		// Directories are removed after their files
+		err := Remove(filepath.Join(tmp, "ab/**"))
+		require.NoError(t, err)
+		assert.NoDirExists(t, filepath.Join(tmp, "ab/sub"))
+		assert.DirExists(t, filepath.Join(tmp, "ab"))
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Remove(filepath.Join(tmp, "missing*"))
+		require.NoError(t, err)
+
+		err = RemoveWith(filepath.Join(tmp, "missing*"), RemoveOptions{MustExist: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "no such file or directory")
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		stderr := RecordStderr()
+		defer stderr.Release()
+
+		err := Remove(filepath.Join(tmp, "*"), RemoveRecursive, RemoveDryRun)
+		require.NoError(t, err)
+		assert.Contains(t, stderr.Output(), "[dry run] rm -r "+filepath.Join(tmp, "ab"))
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+		assertFile(t, filepath.Join(tmp, "ab/ab1.txt"))
+	})
+}
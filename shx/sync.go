@@ -0,0 +1,304 @@
+package shx
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/carolynvs/magex/internal/filehash"
+	"github.com/magefile/mage/mg"
+)
+
+// SyncOptions are the set of options that can be passed to Sync.
+type SyncOptions struct {
+	// Checksum compares the contents of files using a SHA-256 hash to detect
+	// changes. By default, files are considered changed when their size or
+	// modification time is different.
+	Checksum bool
+
+	// Delete removes files and directories in the destination that are not in
+	// the source. Files that are excluded, or not included, are never deleted.
+	Delete bool
+
+	// Include limits the synchronized files to those matching at least one
+	// gitignore-style pattern, such as *.go or docs/. When empty, all files are
+	// synchronized.
+	Include []string
+
+	// Exclude skips files and directories matching any gitignore-style pattern,
+	// such as .git, node_modules or **/testdata.
+	Exclude []string
+}
+
+// SyncResult is a summary of the changes made to the destination by Sync.
+// Paths are relative to the destination directory.
+type SyncResult struct {
+	// Added are files and directories that were copied from the source and did
+	// not exist in the destination.
+	Added []string
+
+	// Updated are files that were different in the destination and were
+	// overwritten with the source.
+	Updated []string
+
+	// Removed are files and directories that were deleted from the destination
+	// because they were not in the source.
+	Removed []string
+}
+
+// Changed returns true when Sync modified the destination.
+func (r SyncResult) Changed() bool {
+	return len(r.Added) > 0 || len(r.Updated) > 0 || len(r.Removed) > 0
+}
+
+// String summarizes the number of files changed.
+func (r SyncResult) String() string {
+	return fmt.Sprintf("%d added, %d updated, %d removed", len(r.Added), len(r.Updated), len(r.Removed))
+}
+
+// Sync mirrors the contents of the source directory into the destination
+// directory, copying only files that are new or have changed. Use this to
+// refresh a generated directory without wiping it each time. The destination
+// is created if it does not exist, though its parent directory must exist.
+// Files are copied with their permissions and modification times.
+func Sync(src string, dest string, opts SyncOptions) (SyncResult, error) {
+	var result SyncResult
+
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return result, err
+	}
+	if !srcInfo.IsDir() {
+		return result, fmt.Errorf("cannot sync %s: not a directory", src)
+	}
+
+	if err := os.MkdirAll(dest, srcInfo.Mode().Perm()); err != nil {
+		return result, err
+	}
+
+	filter := pathFilter{Include: opts.Include, Exclude: opts.Exclude}
+	srcFiles := make(map[string]bool)
+	srcDirs := make(map[string]bool)
+	err = filepath.Walk(src, func(srcPath string, srcInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, srcPath)
+		if err != nil {
+			return fmt.Errorf("error determining the relative path between %s and %s: %w", src, srcPath, err)
+		}
+		if rel == "." {
+			return nil
+		}
+
+		if filter.Excluded(rel, srcInfo.IsDir()) {
+			if srcInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		destPath := filepath.Join(dest, rel)
+		if srcInfo.IsDir() {
+			srcDirs[rel] = true
+
+			// When filtering with include patterns, only create directories that have included files
+			if !filter.Included(rel, true) {
+				return nil
+			}
+			added, err := syncDirectory(srcInfo, destPath)
+			if added {
+				result.Added = append(result.Added, rel)
+			}
+			return err
+		}
+
+		if !filter.Included(rel, false) {
+			return nil
+		}
+		srcFiles[rel] = true
+
+		if err := mkdirLike(filepath.Dir(srcPath), filepath.Dir(destPath)); err != nil {
+			return err
+		}
+
+		destInfo, err := os.Lstat(destPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if destInfo != nil {
+			changed, err := fileChanged(srcPath, srcInfo, destPath, destInfo, opts.Checksum)
+			if err != nil || !changed {
+				return err
+			}
+			if err := os.RemoveAll(destPath); err != nil {
+				return err
+			}
+		}
+
+		if err := copyPreservingFile(srcPath, destPath, srcInfo); err != nil {
+			return err
+		}
+		if destInfo == nil {
+			result.Added = append(result.Added, rel)
+		} else {
+			result.Updated = append(result.Updated, rel)
+		}
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("error syncing %s to %s: %w", src, dest, err)
+	}
+
+	if opts.Delete {
+		result.Removed, err = removeExtraneous(dest, filter, srcFiles, srcDirs)
+		if err != nil {
+			return result, fmt.Errorf("error removing extraneous files from %s: %w", dest, err)
+		}
+	}
+
+	if mg.Verbose() {
+		logSyncChanges("+", result.Added)
+		logSyncChanges("~", result.Updated)
+		logSyncChanges("-", result.Removed)
+	}
+	return result, nil
+}
+
+// syncDirectory ensures that the destination is a directory, replacing a file
+// of the same name if necessary, and returns if it was created.
+func syncDirectory(srcInfo os.FileInfo, destPath string) (bool, error) {
+	destInfo, err := os.Lstat(destPath)
+	if err == nil && destInfo.IsDir() {
+		return false, nil
+	}
+	if err == nil {
+		if err := os.Remove(destPath); err != nil {
+			return false, err
+		}
+	}
+
+	if err := os.Mkdir(destPath, srcInfo.Mode().Perm()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// fileChanged compares a source and destination file, returning true when the
+// destination should be replaced with the source.
+func fileChanged(srcPath string, srcInfo os.FileInfo, destPath string, destInfo os.FileInfo, checksum bool) (bool, error) {
+	if srcInfo.Mode().Type() != destInfo.Mode().Type() {
+		return true, nil
+	}
+
+	if srcInfo.Mode()&os.ModeSymlink != 0 {
+		srcTarget, err := os.Readlink(srcPath)
+		if err != nil {
+			return false, err
+		}
+		destTarget, err := os.Readlink(destPath)
+		if err != nil {
+			return false, err
+		}
+		return srcTarget != destTarget, nil
+	}
+
+	if srcInfo.Size() != destInfo.Size() {
+		return true, nil
+	}
+
+	if !checksum {
+		return !srcInfo.ModTime().Equal(destInfo.ModTime()), nil
+	}
+
+	srcHash, err := filehash.Sum(srcPath)
+	if err != nil {
+		return false, err
+	}
+	destHash, err := filehash.Sum(destPath)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(srcHash, destHash), nil
+}
+
+// removeExtraneous deletes files and directories in dest that were not found
+// in the source, skipping paths that are filtered out.
+func removeExtraneous(dest string, filter pathFilter, srcFiles map[string]bool, srcDirs map[string]bool) ([]string, error) {
+	var removed []string
+	var candidateDirs []string
+	err := filepath.Walk(dest, func(destPath string, destInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dest, destPath)
+		if err != nil {
+			return fmt.Errorf("error determining the relative path between %s and %s: %w", dest, destPath, err)
+		}
+		if rel == "." {
+			return nil
+		}
+
+		// Never delete files that are filtered out
+		if filter.Excluded(rel, destInfo.IsDir()) {
+			if destInfo.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if destInfo.IsDir() {
+			if srcDirs[rel] {
+				return nil
+			}
+
+			// When filtering with include patterns, only the included files in
+			// the directory are removed, and the directory only if it is left empty.
+			if len(filter.Include) > 0 && !filter.Included(rel, true) {
+				candidateDirs = append(candidateDirs, rel)
+				return nil
+			}
+
+			if err := os.RemoveAll(destPath); err != nil {
+				return err
+			}
+			removed = append(removed, rel)
+			return filepath.SkipDir
+		}
+
+		if srcFiles[rel] || !filter.Included(rel, false) {
+			return nil
+		}
+		if err := os.Remove(destPath); err != nil {
+			return err
+		}
+		removed = append(removed, rel)
+		return nil
+	})
+	if err != nil {
+		return removed, err
+	}
+
+	// Remove the deepest directories first so that their parents may become empty too.
+	// os.Remove fails for directories that still have files, which are left in place.
+	sort.Sort(sort.Reverse(sort.StringSlice(candidateDirs)))
+	for _, rel := range candidateDirs {
+		if os.Remove(filepath.Join(dest, rel)) == nil {
+			removed = append(removed, rel)
+		}
+	}
+	sort.Strings(removed)
+
+	return removed, nil
+}
+
+func logSyncChanges(prefix string, paths []string) {
+	for _, p := range paths {
+		log.Printf("%s %s\n", prefix, p)
+	}
+}
@@ -0,0 +1,24 @@
+package shx_test
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/carolynvs/magex/shx"
+)
+
+func ExampleSync() {
+	// Refresh the generated docs without wiping the directory each time,
+	// removing pages that are no longer generated
+	result, err := shx.Sync("build/docs", "public/docs", shx.SyncOptions{
+		Delete:  true,
+		Exclude: []string{".git"},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if result.Changed() {
+		fmt.Println("Updated docs:", result)
+	}
+}
@@ -0,0 +1,149 @@
+package shx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSync(t *testing.T) {
+	t.Run("sync into empty directory", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "dest")
+		result, err := Sync("testdata/copy/a", dest, SyncOptions{})
+		require.NoError(t, err, "Sync failed")
+
+		assertFile(t, filepath.Join(dest, "a1.txt"))
+		assertFile(t, filepath.Join(dest, "a2.txt"))
+		assertFile(t, filepath.Join(dest, "ab/ab1.txt"))
+		assertFile(t, filepath.Join(dest, "ab/ab2.txt"))
+
+		wantAdded := []string{"a1.txt", "a2.txt", "ab", filepath.Join("ab", "ab1.txt"), filepath.Join("ab", "ab2.txt")}
+		assert.Equal(t, wantAdded, result.Added)
+		assert.Empty(t, result.Updated)
+		assert.Empty(t, result.Removed)
+		assert.Equal(t, "5 added, 0 updated, 0 removed", result.String())
+	})
+
+	t.Run("only copies changed files", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		src := filepath.Join(tmp, "src")
+		dest := filepath.Join(tmp, "dest")
+		require.NoError(t, Copy("testdata/copy/a", src, CopyRecursive))
+
+		_, err = Sync(src, dest, SyncOptions{})
+		require.NoError(t, err, "initial Sync failed")
+
+		result, err := Sync(src, dest, SyncOptions{})
+		require.NoError(t, err, "Sync failed")
+		assert.False(t, result.Changed(), "nothing should be copied when the files are unchanged")
+
+		// Change the size of a file
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, "a1.txt"), []byte("a1.txt updated"), 0644))
+		// Keep the size the same, but change the modification time
+		require.NoError(t, os.Chtimes(filepath.Join(src, "a2.txt"), time.Now(), time.Now().Add(time.Hour)))
+
+		result, err = Sync(src, dest, SyncOptions{})
+		require.NoError(t, err, "Sync failed")
+		assert.Empty(t, result.Added)
+		assert.Equal(t, []string{"a1.txt", "a2.txt"}, result.Updated)
+
+		contents, err := ioutil.ReadFile(filepath.Join(dest, "a1.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "a1.txt updated", string(contents))
+	})
+
+	t.Run("checksum", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		src := filepath.Join(tmp, "src")
+		dest := filepath.Join(tmp, "dest")
+		require.NoError(t, Copy("testdata/copy/a", src, CopyRecursive))
+
+		_, err = Sync(src, dest, SyncOptions{})
+		require.NoError(t, err, "initial Sync failed")
+
+		// Touch a file without changing its contents, and change a file without changing its size
+		require.NoError(t, os.Chtimes(filepath.Join(src, "a1.txt"), time.Now(), time.Now().Add(time.Hour)))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, "a2.txt"), []byte("A2.TXT"), 0644))
+
+		result, err := Sync(src, dest, SyncOptions{Checksum: true})
+		require.NoError(t, err, "Sync failed")
+		assert.Equal(t, []string{"a2.txt"}, result.Updated)
+	})
+
+	t.Run("delete extraneous files", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "dest")
+		require.NoError(t, os.MkdirAll(filepath.Join(dest, "old/nested"), 0755))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "old/nested/file.txt"), []byte("old"), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "ab.txt"), []byte("old"), 0644))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dest, "keep.log"), []byte("old"), 0644))
+
+		opts := SyncOptions{
+			Delete:  true,
+			Exclude: []string{"*.log"},
+		}
+		result, err := Sync("testdata/copy/a", dest, opts)
+		require.NoError(t, err, "Sync failed")
+
+		assert.Equal(t, []string{"ab.txt", "old"}, result.Removed)
+		assert.NoDirExists(t, filepath.Join(dest, "old"))
+		assert.NoFileExists(t, filepath.Join(dest, "ab.txt"))
+		assert.FileExists(t, filepath.Join(dest, "keep.log"), "excluded files should not be deleted")
+		assertFile(t, filepath.Join(dest, "ab/ab1.txt"))
+	})
+
+	t.Run("keep extraneous files", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, "extra.txt"), []byte("extra"), 0644))
+
+		result, err := Sync("testdata/copy/a", tmp, SyncOptions{})
+		require.NoError(t, err, "Sync failed")
+
+		assert.Empty(t, result.Removed)
+		assert.FileExists(t, filepath.Join(tmp, "extra.txt"))
+	})
+
+	t.Run("replace file with directory", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		require.NoError(t, ioutil.WriteFile(filepath.Join(tmp, "ab"), []byte("not a directory"), 0644))
+
+		_, err = Sync("testdata/copy/a", tmp, SyncOptions{})
+		require.NoError(t, err, "Sync failed")
+
+		assertFile(t, filepath.Join(tmp, "ab/ab1.txt"))
+	})
+
+	t.Run("source is not a directory", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		_, err = Sync("testdata/copy/a/a1.txt", tmp, SyncOptions{})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "not a directory")
+	})
+}
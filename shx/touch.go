@@ -0,0 +1,100 @@
+package shx
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type TouchOption int
+
+const (
+	TouchDefault TouchOption = iota
+	// TouchNoCreate does not create files that do not exist.
+	TouchNoCreate
+	// TouchDryRun logs what would be touched, without changing anything.
+	TouchDryRun
+)
+
+// TouchOptions are the set of options that can be passed to TouchWith.
+type TouchOptions struct {
+	// NoCreate does not create files that do not exist.
+	NoCreate bool
+
+	// Time is the access and modification time to set. Defaults to the current time.
+	Time time.Time
+
+	// DryRun logs what would be touched, without changing anything.
+	DryRun bool
+}
+
+// Touch updates the access and modification times of files, creating empty
+// files that do not exist, with the specified set of TouchOption.
+// The pattern may use globbing, which is resolved with filepath.Glob, and
+// ** to match zero or more directories, updating each matching file. When a
+// pattern without wildcards does not exist, the file is created.
+func Touch(pattern string, opts ...TouchOption) error {
+	var combinedOpts TouchOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	return TouchWith(pattern, TouchOptions{
+		NoCreate: combinedOpts&TouchNoCreate == TouchNoCreate,
+		DryRun:   combinedOpts&TouchDryRun == TouchDryRun,
+	})
+}
+
+// TouchWith updates the access and modification times of files with the
+// specified TouchOptions.
+func TouchWith(pattern string, opts TouchOptions) error {
+	t := opts.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	items, err := glob(pattern)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(items))
+	for _, item := range items {
+		paths = append(paths, item.Path)
+	}
+	if len(paths) == 0 && !hasMeta(filepath.ToSlash(pattern)) {
+		paths = append(paths, pattern)
+	}
+
+	for _, path := range paths {
+		logFileOperation(opts.DryRun, "touch %s", path)
+		if opts.DryRun {
+			continue
+		}
+
+		if err := touch(path, t, opts.NoCreate); err != nil {
+			return fmt.Errorf("could not touch %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func touch(path string, t time.Time, noCreate bool) error {
+	err := os.Chtimes(path, t, t)
+	if !os.IsNotExist(err) {
+		return err
+	}
+	if noCreate {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(path, t, t)
+}
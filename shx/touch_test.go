@@ -0,0 +1,72 @@
+package shx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTouch(t *testing.T) {
+	t.Run("update existing files", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		err := TouchWith(filepath.Join(tmp, "**/*1.txt"), TouchOptions{Time: ts})
+		require.NoError(t, err)
+
+		for _, f := range []string{"a1.txt", "ab/ab1.txt"} {
+			info, err := os.Stat(filepath.Join(tmp, f))
+			require.NoError(t, err)
+			assert.True(t, ts.Equal(info.ModTime()), "the modification time of %s should be updated", f)
+		}
+		info, err := os.Stat(filepath.Join(tmp, "a2.txt"))
+		require.NoError(t, err)
+		assert.False(t, ts.Equal(info.ModTime()), "unmatched files should not be touched")
+
+		assertFile(t, filepath.Join(tmp, "a1.txt"))
+	})
+
+	t.Run("create file", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Touch(filepath.Join(tmp, "new.txt"))
+		require.NoError(t, err)
+
+		contents, err := ioutil.ReadFile(filepath.Join(tmp, "new.txt"))
+		require.NoError(t, err)
+		assert.Empty(t, contents)
+	})
+
+	t.Run("no create", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Touch(filepath.Join(tmp, "new.txt"), TouchNoCreate)
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(tmp, "new.txt"))
+	})
+
+	t.Run("glob does not create files", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		err := Touch(filepath.Join(tmp, "*.go"))
+		require.NoError(t, err)
+		assert.NoFileExists(t, filepath.Join(tmp, "*.go"))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		tmp := copyTestData(t)
+
+		stderr := RecordStderr()
+		defer stderr.Release()
+
+		err := Touch(filepath.Join(tmp, "new.txt"), TouchDryRun)
+		require.NoError(t, err)
+		assert.Contains(t, stderr.Output(), "[dry run] touch "+filepath.Join(tmp, "new.txt"))
+		assert.NoFileExists(t, filepath.Join(tmp, "new.txt"))
+	})
+}
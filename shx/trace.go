@@ -0,0 +1,422 @@
+package shx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// TraceEnvVar is the environment variable that enables tracing every command
+// that is executed, and is set to the path of the file where the trace is
+// written. When the file has a .json extension, the trace is written in the
+// Chrome trace event format, which can be opened with chrome://tracing or
+// https://ui.perfetto.dev. Otherwise the trace is written as a timing table.
+// Each command is appended to the file when it completes, so the file is
+// complete even when the build fails, but the JSON array is not closed,
+// which trace viewers accept, and the table does not have a total. Nested
+// magex processes, such as a target that runs mage, append to the same file.
+//
+//	MAGEX_TRACE=trace.json mage build
+const TraceEnvVar = "MAGEX_TRACE"
+
+// traceStartedEnvVar is set to the path of the trace file by the process that
+// created it, and is inherited by the commands that it runs, so that nested
+// processes append to the file instead of replacing it.
+const traceStartedEnvVar = "MAGEX_TRACE_STARTED"
+
+// TraceEvent records the execution of a command.
+type TraceEvent struct {
+	// Command that was executed, including its arguments.
+	Command string `json:"command"`
+
+	// Dir is the working directory of the command.
+	Dir string `json:"dir,omitempty"`
+
+	// Start is when the command was started.
+	Start time.Time `json:"start"`
+
+	// Duration is how long the command ran.
+	Duration time.Duration `json:"duration"`
+
+	// ExitCode of the command.
+	ExitCode int `json:"exitCode"`
+
+	// Target is the mage target that executed the command, when it can be
+	// determined from the call stack.
+	Target string `json:"target,omitempty"`
+}
+
+var tracer = &commandTracer{}
+
+type commandTracer struct {
+	mu      sync.Mutex
+	enabled bool
+	events  []TraceEvent
+
+	// file is the trace file named by TraceEnvVar, which is opened when the
+	// first command is run.
+	file *traceFile
+}
+
+// StartTrace begins recording every command that is executed. Tracing is
+// started automatically when TraceEnvVar is set.
+func StartTrace() {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	tracer.enabled = true
+}
+
+// StopTrace stops recording commands and discards the recorded events.
+func StopTrace() {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	tracer.enabled = false
+	tracer.events = nil
+}
+
+// TraceEvents returns the commands recorded since the trace was started.
+func TraceEvents() []TraceEvent {
+	tracer.mu.Lock()
+	defer tracer.mu.Unlock()
+	return append([]TraceEvent(nil), tracer.events...)
+}
+
+// record the command execution when tracing is enabled.
+func (t *commandTracer) record(c PreparedCommand, result CommandResult) {
+	tracePath := os.Getenv(TraceEnvVar)
+
+	t.mu.Lock()
+	if !t.enabled && tracePath == "" {
+		t.mu.Unlock()
+		return
+	}
+
+	defer t.mu.Unlock()
+
+	start := result.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	event := TraceEvent{
+		Command:  c.String(),
+		Dir:      c.Cmd.Dir,
+		Start:    start,
+		Duration: result.Duration,
+		ExitCode: result.ExitCode,
+		Target:   callerTarget(),
+	}
+	t.events = append(t.events, event)
+
+	if f := t.openFile(tracePath); f != nil {
+		f.append(event)
+	}
+}
+
+// start opens the trace file named by TraceEnvVar before a command is run,
+// so that it is created before any nested magex process run by the command,
+// and tells the command to append to the file.
+func (t *commandTracer) start(c *PreparedCommand) {
+	tracePath := os.Getenv(TraceEnvVar)
+	if tracePath == "" {
+		return
+	}
+
+	t.mu.Lock()
+	t.openFile(tracePath)
+	t.mu.Unlock()
+
+	// The command's environment may have been copied before the file was created
+	if os.Getenv(traceStartedEnvVar) == tracePath && c.Getenv(TraceEnvVar) == tracePath {
+		if _, ok := c.LookupEnv(traceStartedEnvVar); !ok {
+			c.Env(traceStartedEnvVar + "=" + tracePath)
+		}
+	}
+}
+
+// openFile returns the open trace file, opening it when the path changed, or
+// nil when the path is empty. Call it while holding the lock.
+func (t *commandTracer) openFile(tracePath string) *traceFile {
+	if t.file != nil && t.file.path != tracePath {
+		t.file.close()
+		t.file = nil
+	}
+	if tracePath == "" {
+		return nil
+	}
+	if t.file == nil {
+		t.file = openTraceFile(tracePath)
+	}
+	return t.file
+}
+
+// traceFile appends each recorded command to the file named by TraceEnvVar.
+type traceFile struct {
+	path    string
+	f       *os.File
+	json    bool
+	pid     int
+	threads map[string]int
+	err     error
+}
+
+func openTraceFile(path string) *traceFile {
+	tf := &traceFile{
+		path:    path,
+		json:    strings.EqualFold(filepath.Ext(path), ".json"),
+		pid:     os.Getpid(),
+		threads: make(map[string]int),
+	}
+
+	// Only the top-level process starts a new file, each write is appended
+	// so that nested processes do not overwrite each other.
+	nested := os.Getenv(traceStartedEnvVar) == path
+	flags := os.O_CREATE | os.O_APPEND | os.O_WRONLY
+	if !nested {
+		flags |= os.O_TRUNC
+	}
+	tf.f, tf.err = os.OpenFile(path, flags, 0644)
+	if tf.err != nil {
+		tf.warn()
+		return tf
+	}
+	if nested {
+		return tf
+	}
+	os.Setenv(traceStartedEnvVar, path)
+
+	if tf.json {
+		tf.write([]byte("[\n"))
+	} else {
+		tf.write(formatTraceRow("DURATION", "EXIT", "TARGET", "DIR", "COMMAND"))
+	}
+	return tf
+}
+
+func (tf *traceFile) append(e TraceEvent) {
+	if !tf.json {
+		tf.write(formatTraceRow(formatTraceDuration(e.Duration), strconv.Itoa(e.ExitCode), e.Target, e.Dir, e.Command))
+		return
+	}
+
+	// Name the row for a target when it is first used
+	tid, ok := tf.threads[e.Target]
+	if !ok {
+		tid = len(tf.threads) + 1
+		tf.threads[e.Target] = tid
+		tf.writeChromeEvent(newChromeThreadName(e.Target, tid))
+	}
+
+	// The timestamps are relative to the epoch, because the trace is not
+	// available when the file is opened to find the earliest command.
+	tf.writeChromeEvent(newChromeTraceEvent(e, time.Unix(0, 0), tid))
+}
+
+// writeChromeEvent writes the event followed by a comma, which trace viewers
+// accept at the end of the unclosed array, so that each event is written with
+// a single append, regardless of which process wrote the previous event.
+func (tf *traceFile) writeChromeEvent(e chromeTraceEvent) {
+	// Display the commands from each process in their own group
+	e.Pid = tf.pid
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		tf.err = err
+		tf.warn()
+		return
+	}
+	tf.write(append(data, ",\n"...))
+}
+
+func (tf *traceFile) write(data []byte) {
+	if tf.err != nil {
+		return
+	}
+	if _, tf.err = tf.f.Write(data); tf.err != nil {
+		tf.warn()
+	}
+}
+
+// warn that the trace file could not be written, which is only logged once
+// because the file is not written after an error.
+func (tf *traceFile) warn() {
+	log.Printf("WARNING: could not write the command trace to %s: %s\n", tf.path, tf.err)
+}
+
+func (tf *traceFile) close() {
+	if tf.f != nil {
+		tf.f.Close()
+	}
+}
+
+// formatTraceRow formats a row of the timing table that is written to the
+// trace file. The columns have a fixed width because the table is written
+// one row at a time.
+func formatTraceRow(duration string, exitCode string, target string, dir string, command string) []byte {
+	return []byte(fmt.Sprintf("%-10s  %-4s  %-20s  %-20s  %s\n", duration, exitCode, target, dir, command))
+}
+
+// WriteChromeTrace writes the recorded commands in the Chrome trace event
+// format, which can be opened with chrome://tracing or https://ui.perfetto.dev.
+// Commands are grouped by their mage target.
+func WriteChromeTrace(w io.Writer) error {
+	return writeChromeTrace(w, TraceEvents())
+}
+
+// chromeTraceEvent is a complete event in the Chrome trace event format.
+// See https://docs.google.com/document/d/1CvAClvFfyA5R-PhYUmn5OOQtYMH4h6I0nSsKchNAySU
+type chromeTraceEvent struct {
+	Name     string                 `json:"name"`
+	Category string                 `json:"cat"`
+	Phase    string                 `json:"ph"`
+	Start    int64                  `json:"ts"`
+	Duration int64                  `json:"dur"`
+	Pid      int                    `json:"pid"`
+	Tid      int                    `json:"tid"`
+	Args     map[string]interface{} `json:"args,omitempty"`
+}
+
+func writeChromeTrace(w io.Writer, events []TraceEvent) error {
+	var origin time.Time
+	for _, e := range events {
+		if origin.IsZero() || e.Start.Before(origin) {
+			origin = e.Start
+		}
+	}
+
+	// Display each target on its own row
+	threads := make(map[string]int)
+	traceEvents := make([]chromeTraceEvent, 0, len(events))
+	for _, e := range events {
+		tid, ok := threads[e.Target]
+		if !ok {
+			tid = len(threads) + 1
+			threads[e.Target] = tid
+		}
+
+		traceEvents = append(traceEvents, newChromeTraceEvent(e, origin, tid))
+	}
+
+	for target, tid := range threads {
+		traceEvents = append(traceEvents, newChromeThreadName(target, tid))
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"traceEvents": traceEvents})
+}
+
+// newChromeTraceEvent converts a command to a complete event, starting at
+// its offset from origin, on the row for its target.
+func newChromeTraceEvent(e TraceEvent, origin time.Time, tid int) chromeTraceEvent {
+	args := map[string]interface{}{
+		"exitCode": e.ExitCode,
+	}
+	if e.Dir != "" {
+		args["dir"] = e.Dir
+	}
+	if e.Target != "" {
+		args["target"] = e.Target
+	}
+
+	return chromeTraceEvent{
+		Name:     e.Command,
+		Category: "command",
+		Phase:    "X",
+		Start:    e.Start.Sub(origin).Microseconds(),
+		Duration: e.Duration.Microseconds(),
+		Pid:      1,
+		Tid:      tid,
+		Args:     args,
+	}
+}
+
+// newChromeThreadName names the row for a target.
+func newChromeThreadName(target string, tid int) chromeTraceEvent {
+	name := target
+	if name == "" {
+		name = "(no target)"
+	}
+	return chromeTraceEvent{
+		Name:  "thread_name",
+		Phase: "M",
+		Pid:   1,
+		Tid:   tid,
+		Args:  map[string]interface{}{"name": name},
+	}
+}
+
+// WriteTraceTable writes the recorded commands as a table of their durations,
+// in the order that they were started, followed by the total time spent
+// running commands.
+func WriteTraceTable(w io.Writer) error {
+	return writeTraceTable(w, TraceEvents())
+}
+
+func writeTraceTable(w io.Writer, events []TraceEvent) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DURATION\tEXIT\tTARGET\tDIR\tCOMMAND")
+
+	var total time.Duration
+	for _, e := range events {
+		total += e.Duration
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", formatTraceDuration(e.Duration), e.ExitCode, e.Target, e.Dir, e.Command)
+	}
+	fmt.Fprintf(tw, "%s\t\t\t\t%d commands\n", formatTraceDuration(total), len(events))
+	return tw.Flush()
+}
+
+func formatTraceDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// callerTarget finds the mage target that executed the command from the call
+// stack. Mage compiles the magefile into the main package, so the outermost
+// function in the main package, other than the generated main function, is
+// the target.
+func callerTarget() string {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var target string
+	for {
+		frame, more := frames.Next()
+		if name := targetName(frame.Function); name != "" {
+			target = name
+		}
+		if !more {
+			break
+		}
+	}
+	return target
+}
+
+// targetName returns the name of the mage target for a function in the call
+// stack, or an empty string when the function is not in the magefile.
+func targetName(function string) string {
+	name := strings.TrimPrefix(function, "main.")
+	if name == function || name == "main" || strings.HasPrefix(name, "main.") || strings.HasPrefix(name, "init") {
+		return ""
+	}
+
+	// Trim anonymous functions defined in the target, e.g. Build.func1.2
+	parts := strings.Split(name, ".")
+	for len(parts) > 1 && isAnonymousFunc(parts[len(parts)-1]) {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+func isAnonymousFunc(name string) bool {
+	name = strings.TrimLeft(name, "0123456789")
+	return name == "" || strings.HasPrefix(name, "func") || strings.HasPrefix(name, "gowrap")
+}
@@ -0,0 +1,199 @@
+package shx
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrace(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		StopTrace()
+
+		require.NoError(t, RunE("go", "version"))
+		assert.Empty(t, TraceEvents())
+	})
+
+	t.Run("records commands", func(t *testing.T) {
+		StartTrace()
+		defer StopTrace()
+
+		require.NoError(t, Command("go", "version").In(".").RunE())
+		require.Error(t, RunE("go", "fakecommand"))
+
+		events := TraceEvents()
+		require.Len(t, events, 2)
+		assert.Equal(t, "go version", events[0].Command)
+		assert.Equal(t, ".", events[0].Dir)
+		assert.Equal(t, 0, events[0].ExitCode)
+		assert.False(t, events[0].Start.IsZero(), "Start should be set")
+		assert.Equal(t, "go fakecommand", events[1].Command)
+		assert.Equal(t, 2, events[1].ExitCode)
+	})
+
+	t.Run("env var", func(t *testing.T) {
+		StopTrace()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		tracePath := filepath.Join(tmp, "trace.json")
+		os.Setenv(TraceEnvVar, tracePath)
+		defer os.Unsetenv(TraceEnvVar)
+		defer os.Unsetenv(traceStartedEnvVar)
+		defer StopTrace()
+
+		require.NoError(t, RunE("go", "version"))
+		require.Error(t, RunE("go", "fakecommand"))
+
+		traceEvents := readTraceFile(t, tracePath)
+		require.Len(t, traceEvents, 3, "expected the thread name and both commands")
+		assert.Equal(t, "thread_name", traceEvents[0].Name)
+		assert.Equal(t, "M", traceEvents[0].Phase)
+		assert.Equal(t, "go version", traceEvents[1].Name)
+		assert.Equal(t, "X", traceEvents[1].Phase)
+		assert.Equal(t, "go fakecommand", traceEvents[2].Name)
+		assert.EqualValues(t, 2, traceEvents[2].Args["exitCode"], "exit code")
+	})
+
+	t.Run("env var table", func(t *testing.T) {
+		StopTrace()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		tracePath := filepath.Join(tmp, "trace.txt")
+		os.Setenv(TraceEnvVar, tracePath)
+		defer os.Unsetenv(TraceEnvVar)
+		defer os.Unsetenv(traceStartedEnvVar)
+		defer StopTrace()
+
+		require.NoError(t, RunE("go", "version"))
+		require.Error(t, RunE("go", "fakecommand"))
+
+		contents, err := ioutil.ReadFile(tracePath)
+		require.NoError(t, err, "the trace file should be written")
+
+		lines := strings.Split(strings.TrimSuffix(string(contents), "\n"), "\n")
+		require.Len(t, lines, 3, "expected the header and both commands")
+		assert.True(t, strings.HasPrefix(lines[0], "DURATION"), "the header should be written first")
+		assert.True(t, strings.HasSuffix(lines[1], "go version"))
+		assert.True(t, strings.HasSuffix(lines[2], "go fakecommand"))
+	})
+
+	t.Run("env var nested process", func(t *testing.T) {
+		StopTrace()
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		tracePath := filepath.Join(tmp, "trace.json")
+		os.Setenv(TraceEnvVar, tracePath)
+		defer os.Unsetenv(TraceEnvVar)
+		defer os.Unsetenv(traceStartedEnvVar)
+		defer StopTrace()
+
+		// The environment is copied before the trace file is created
+		cmd := Command("go", "version")
+		require.NoError(t, cmd.RunE())
+		assert.Equal(t, tracePath, cmd.Getenv(traceStartedEnvVar), "nested processes should append to the trace file")
+
+		// Simulate a nested process, which opens the file again
+		tracer.mu.Lock()
+		tracer.file.close()
+		tracer.file = nil
+		tracer.mu.Unlock()
+		require.NoError(t, RunE("go", "env", "GOOS"))
+
+		traceEvents := readTraceFile(t, tracePath)
+		require.Len(t, traceEvents, 4, "expected the thread name and command from each process")
+		assert.Equal(t, "go version", traceEvents[1].Name)
+		assert.Equal(t, "go env GOOS", traceEvents[3].Name)
+	})
+}
+
+// readTraceFile parses the events written to a Chrome trace file, which is
+// left open so that each command can be appended.
+func readTraceFile(t *testing.T, path string) []chromeTraceEvent {
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err, "the trace file should be written")
+
+	var traceEvents []chromeTraceEvent
+	contents = bytes.TrimSuffix(contents, []byte(",\n"))
+	require.NoError(t, json.Unmarshal(append(contents, ']'), &traceEvents))
+	return traceEvents
+}
+
+func TestWriteTraceTable(t *testing.T) {
+	start := time.Now()
+	events := []TraceEvent{
+		{Command: "go build", Start: start, Duration: 1500 * time.Millisecond, Target: "Build"},
+		{Command: "go test ./...", Dir: "pkg", Start: start.Add(2 * time.Second), Duration: 2 * time.Second, ExitCode: 1, Target: "Test"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeTraceTable(&buf, events))
+
+	want := `DURATION  EXIT  TARGET  DIR  COMMAND
+1.5s      0     Build        go build
+2s        1     Test    pkg  go test ./...
+3.5s                         2 commands
+`
+	assert.Equal(t, want, buf.String())
+}
+
+func TestWriteChromeTrace(t *testing.T) {
+	start := time.Now()
+	events := []TraceEvent{
+		{Command: "go build", Start: start, Duration: time.Second, Target: "Build"},
+		{Command: "go test", Start: start.Add(time.Second), Duration: time.Second, ExitCode: 1, Target: "Test"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeChromeTrace(&buf, events))
+
+	var trace struct {
+		TraceEvents []chromeTraceEvent `json:"traceEvents"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &trace))
+	require.Len(t, trace.TraceEvents, 4)
+
+	build := trace.TraceEvents[0]
+	assert.Equal(t, "go build", build.Name)
+	assert.Equal(t, int64(0), build.Start, "timestamps should be relative to the first command")
+	assert.Equal(t, int64(1000000), build.Duration)
+
+	test := trace.TraceEvents[1]
+	assert.Equal(t, int64(1000000), test.Start)
+	assert.NotEqual(t, build.Tid, test.Tid, "each target should be on its own row")
+	assert.Equal(t, float64(1), test.Args["exitCode"])
+}
+
+func TestTargetName(t *testing.T) {
+	testcases := map[string]string{
+		"main.Build":                          "Build",
+		"main.Test.Unit":                      "Test.Unit",
+		"main.Build.func1":                    "Build",
+		"main.Build.func1.2":                  "Build",
+		"main.Build.gowrap1":                  "Build",
+		"main.main":                           "",
+		"main.main.func1":                     "",
+		"main.init.0":                         "",
+		"github.com/carolynvs/magex/shx.RunE": "",
+		"github.com/magefile/mage/mg.runDeps": "",
+	}
+	for function, want := range testcases {
+		assert.Equal(t, want, targetName(function), function)
+	}
+}
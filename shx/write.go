@@ -0,0 +1,111 @@
+package shx
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+type WriteOption int
+
+const (
+	WriteDefault WriteOption = iota
+	// WriteSkipUnchanged does not write the file when it already has the same
+	// contents, preserving its modification time.
+	WriteSkipUnchanged
+)
+
+// WriteFileAtomic writes data to a file, so that the file either has its
+// previous contents or the new contents, and is never partially written.
+// The data is written to a temporary file in the same directory, flushed to
+// disk and then renamed to the destination path. Like ioutil.WriteFile,
+// perm is used when the file does not exist, otherwise the permissions of the
+// existing file are preserved.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode, opts ...WriteOption) error {
+	var combinedOpts WriteOption
+	for _, opt := range opts {
+		combinedOpts |= opt
+	}
+
+	if combinedOpts&WriteSkipUnchanged == WriteSkipUnchanged {
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Equal(existing, data) {
+			if mg.Verbose() {
+				log.Printf("%s is unchanged\n", path)
+			}
+			return nil
+		}
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	return writeAtomic(path, perm, time.Time{}, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// RenderTemplateFile renders a Go template with the specified data and writes
+// the result to dest with WriteFileAtomic. New files are created with 0644
+// permissions.
+func RenderTemplateFile(tmplContents string, data interface{}, dest string, opts ...WriteOption) error {
+	tmpl, err := template.New(filepath.Base(dest)).Parse(tmplContents)
+	if err != nil {
+		return fmt.Errorf("error parsing the template for %s: %w", dest, err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return fmt.Errorf("error rendering the template for %s with data: %#v: %w", dest, data, err)
+	}
+
+	return WriteFileAtomic(dest, buf.Bytes(), 0644, opts...)
+}
+
+// writeAtomic calls write with a temporary file in the same directory as dest,
+// and then renames it to dest. The temporary file is removed when anything fails.
+// When modTime is set, it is applied to the file before it is renamed.
+func writeAtomic(dest string, perm os.FileMode, modTime time.Time, write func(w io.Writer) error) error {
+	tmpF, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpF.Name()
+
+	err = func() error {
+		defer tmpF.Close()
+
+		if err := write(tmpF); err != nil {
+			return err
+		}
+		if err := tmpF.Chmod(perm); err != nil {
+			return err
+		}
+		if err := tmpF.Sync(); err != nil {
+			return err
+		}
+		return tmpF.Close()
+	}()
+	if err == nil && !modTime.IsZero() {
+		err = os.Chtimes(tmpPath, modTime, modTime)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dest)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("error writing %s: %w", dest, err)
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package shx_test
+
+import (
+	"github.com/carolynvs/magex/shx"
+)
+
+func ExampleWriteFileAtomic() {
+	// Write a config file, without leaving a partially written file behind on failure
+	shx.WriteFileAtomic("/tmp/config.yaml", []byte("debug: true\n"), 0644)
+}
+
+func ExampleRenderTemplateFile() {
+	// Generate a config file, only touching it when the rendered contents changed
+	tmpl := "image: {{.Registry}}/app:{{.Version}}\n"
+	data := map[string]string{
+		"Registry": "localhost:5000",
+		"Version":  "v1.0.0",
+	}
+	shx.RenderTemplateFile(tmpl, data, "/tmp/values.yaml", shx.WriteSkipUnchanged)
+}
@@ -0,0 +1,125 @@
+package shx
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	t.Run("new file", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "config.yaml")
+		err = WriteFileAtomic(dest, []byte("a: 1"), 0600)
+		require.NoError(t, err, "WriteFileAtomic failed")
+
+		contents, err := ioutil.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, "a: 1", string(contents))
+
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(dest)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		}
+
+		leftovers, err := filepath.Glob(filepath.Join(tmp, ".*.tmp*"))
+		require.NoError(t, err)
+		assert.Empty(t, leftovers, "temporary files should be cleaned up")
+	})
+
+	t.Run("overwrite preserves permissions", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("unix file permissions are not supported on windows")
+		}
+
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "run.sh")
+		require.NoError(t, ioutil.WriteFile(dest, []byte("echo old"), 0755))
+		require.NoError(t, os.Chmod(dest, 0755))
+
+		err = WriteFileAtomic(dest, []byte("echo new"), 0644)
+		require.NoError(t, err, "WriteFileAtomic failed")
+
+		info, err := os.Stat(dest)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	})
+
+	t.Run("skip unchanged", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		dest := filepath.Join(tmp, "config.yaml")
+		require.NoError(t, ioutil.WriteFile(dest, []byte("a: 1"), 0644))
+		past := time.Now().Add(-time.Hour).Truncate(time.Second)
+		require.NoError(t, os.Chtimes(dest, past, past))
+
+		err = WriteFileAtomic(dest, []byte("a: 1"), 0644, WriteSkipUnchanged)
+		require.NoError(t, err, "WriteFileAtomic failed")
+
+		info, err := os.Stat(dest)
+		require.NoError(t, err)
+		assert.True(t, past.Equal(info.ModTime()), "the unchanged file should not be written")
+
+		err = WriteFileAtomic(dest, []byte("a: 2"), 0644, WriteSkipUnchanged)
+		require.NoError(t, err, "WriteFileAtomic failed")
+
+		contents, err := ioutil.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, "a: 2", string(contents))
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		tmp, err := ioutil.TempDir("", "magex")
+		require.NoError(t, err, "could not create temp directory for test")
+		defer os.RemoveAll(tmp)
+
+		err = WriteFileAtomic(filepath.Join(tmp, "missing/config.yaml"), []byte("a: 1"), 0644)
+		require.Error(t, err)
+	})
+}
+
+func TestRenderTemplateFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	defer os.RemoveAll(tmp)
+
+	dest := filepath.Join(tmp, "config.yaml")
+	data := struct{ Name string }{Name: "magex"}
+	err = RenderTemplateFile("name: {{.Name}}", data, dest)
+	require.NoError(t, err, "RenderTemplateFile failed")
+
+	contents, err := ioutil.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, "name: magex", string(contents))
+
+	t.Run("invalid template", func(t *testing.T) {
+		err = RenderTemplateFile("name: {{.Name", data, dest)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error parsing the template")
+	})
+
+	t.Run("missing field", func(t *testing.T) {
+		err = RenderTemplateFile("name: {{.Missing}}", data, dest)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "error rendering the template")
+
+		contents, err := ioutil.ReadFile(dest)
+		require.NoError(t, err)
+		assert.Equal(t, "name: magex", string(contents), "the file should not be modified when rendering fails")
+	})
+}
@@ -0,0 +1,5 @@
+// Package targetx provides helpers that complement the github.com/magefile/mage/target
+// package. Instead of comparing modification times, which are reset by a fresh
+// git checkout, targets are considered up-to-date by comparing the content
+// hashes of their inputs and outputs with the last successful run.
+package targetx
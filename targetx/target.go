@@ -0,0 +1,229 @@
+package targetx
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+
+	"github.com/carolynvs/magex/internal/filehash"
+	"github.com/carolynvs/magex/shx"
+)
+
+// DefaultStateDir is the directory, relative to the current working directory,
+// where the state of each target is recorded when Options.StateDir is not set.
+// Add it to your .gitignore.
+const DefaultStateDir = ".magex/targets"
+
+// Options are the set of options that describe a target's files.
+type Options struct {
+	// Name uniquely identifies the target, such as "build" or "docs". Required.
+	Name string
+
+	// Inputs are glob patterns of the files used by the target, such as
+	// go.mod or **/*.go. Directories are hashed recursively.
+	Inputs []string
+
+	// Outputs are glob patterns of the files generated by the target, such as
+	// bin/app. Directories are hashed recursively. The target is not
+	// up-to-date when an output pattern does not match any files.
+	Outputs []string
+
+	// StateDir is the directory where the state of the target is recorded.
+	// Defaults to DefaultStateDir.
+	StateDir string
+}
+
+// state is the record of a target's files, saved after a successful run.
+type state struct {
+	Inputs  map[string]string `json:"inputs"`
+	Outputs map[string]string `json:"outputs"`
+}
+
+// Changed determines if the target should run, because the inputs or outputs
+// are different from the last time Save was called, or the target has never
+// been saved.
+func Changed(opts Options) (bool, error) {
+	changed, _, err := checkState(opts)
+	return changed, err
+}
+
+// checkState returns if the target changed, and its current state.
+func checkState(opts Options) (bool, state, error) {
+	current, outputsFound, err := hashState(opts)
+	if err != nil {
+		return false, state{}, err
+	}
+	if !outputsFound {
+		return true, current, nil
+	}
+
+	statePath, err := getStatePath(opts)
+	if err != nil {
+		return false, state{}, err
+	}
+
+	contents, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, current, nil
+		}
+		return false, state{}, fmt.Errorf("could not read the state of target %s: %w", opts.Name, err)
+	}
+
+	var saved state
+	if err := json.Unmarshal(contents, &saved); err != nil {
+		// Treat a corrupt state file as if the target never ran
+		return true, current, nil
+	}
+
+	return !reflect.DeepEqual(current, saved), current, nil
+}
+
+// Save records the current hashes of the target's inputs and outputs. Call it
+// after the target completes successfully.
+func Save(opts Options) error {
+	current, _, err := hashState(opts)
+	if err != nil {
+		return err
+	}
+	return saveState(opts, current)
+}
+
+func saveState(opts Options, current state) error {
+	statePath, err := getStatePath(opts)
+	if err != nil {
+		return err
+	}
+
+	contents, err := json.MarshalIndent(current, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not serialize the state of target %s: %w", opts.Name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return fmt.Errorf("could not create the state directory for target %s: %w", opts.Name, err)
+	}
+	if err := shx.WriteFileAtomic(statePath, contents, 0644); err != nil {
+		return fmt.Errorf("could not save the state of target %s: %w", opts.Name, err)
+	}
+	return nil
+}
+
+// Reset removes the recorded state of the target, so that it runs the next
+// time it is called.
+func Reset(opts Options) error {
+	statePath, err := getStatePath(opts)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not reset the state of target %s: %w", opts.Name, err)
+	}
+	return nil
+}
+
+// Run calls the function when the target has changed, and then saves the
+// state of the target when it succeeds. The function is skipped when the
+// target is up-to-date. The inputs are hashed before the function is called,
+// so that inputs that change while it runs are built again the next time.
+func Run(opts Options, fn func() error) error {
+	changed, current, err := checkState(opts)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+
+	// The outputs are generated by the function
+	current.Outputs, _, err = hashFiles(opts.Outputs)
+	if err != nil {
+		return fmt.Errorf("could not hash the outputs of target %s: %w", opts.Name, err)
+	}
+	return saveState(opts, current)
+}
+
+// only allow names that are safe to use as a file name
+var targetNameRegex = regexp.MustCompile(`^[\w.-]+$`)
+
+func getStatePath(opts Options) (string, error) {
+	if !targetNameRegex.MatchString(opts.Name) {
+		return "", fmt.Errorf("invalid target name %q, only letters, numbers, dashes, underscores and periods are allowed", opts.Name)
+	}
+
+	stateDir := opts.StateDir
+	if stateDir == "" {
+		stateDir = DefaultStateDir
+	}
+	return filepath.Join(stateDir, opts.Name+".json"), nil
+}
+
+// hashState returns the current state of the target, and if every output
+// pattern matched at least one file.
+func hashState(opts Options) (state, bool, error) {
+	inputs, _, err := hashFiles(opts.Inputs)
+	if err != nil {
+		return state{}, false, fmt.Errorf("could not hash the inputs of target %s: %w", opts.Name, err)
+	}
+
+	outputs, outputsFound, err := hashFiles(opts.Outputs)
+	if err != nil {
+		return state{}, false, fmt.Errorf("could not hash the outputs of target %s: %w", opts.Name, err)
+	}
+
+	return state{Inputs: inputs, Outputs: outputs}, outputsFound, nil
+}
+
+// hashFiles returns the SHA-256 hash of every file matching the patterns,
+// keyed by the slash separated path, and if every pattern matched at least one file.
+func hashFiles(patterns []string) (map[string]string, bool, error) {
+	hashes := make(map[string]string)
+	allFound := true
+	for _, pattern := range patterns {
+		matches, err := shx.Glob(pattern)
+		if err != nil {
+			return nil, false, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			allFound = false
+		}
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() {
+					return nil
+				}
+
+				key := filepath.ToSlash(path)
+				if _, ok := hashes[key]; ok {
+					return nil
+				}
+
+				hash, err := filehash.Sum(path)
+				if err != nil {
+					return err
+				}
+				hashes[key] = hex.EncodeToString(hash)
+				return nil
+			})
+			if err != nil {
+				return nil, false, err
+			}
+		}
+	}
+	return hashes, allFound, nil
+}
@@ -0,0 +1,18 @@
+package targetx_test
+
+import (
+	"github.com/carolynvs/magex/shx"
+	"github.com/carolynvs/magex/targetx"
+)
+
+func ExampleRun() {
+	// Only build when the source code or the binary changed since the last build
+	opts := targetx.Options{
+		Name:    "build",
+		Inputs:  []string{"go.mod", "go.sum", "**/*.go"},
+		Outputs: []string{"bin/app"},
+	}
+	targetx.Run(opts, func() error {
+		return shx.RunV("go", "build", "-o", "bin/app", ".")
+	})
+}
@@ -0,0 +1,118 @@
+package targetx
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRun(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "pkg"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "main.go"), []byte("package main"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "pkg/lib.go"), []byte("package pkg"), 0644))
+
+	output := filepath.Join(tmp, "bin/app")
+	opts := Options{
+		Name:     "build",
+		Inputs:   []string{filepath.Join(src, "**/*.go")},
+		Outputs:  []string{output},
+		StateDir: filepath.Join(tmp, "state"),
+	}
+
+	var runs int
+	build := func() error {
+		runs++
+		require.NoError(t, os.MkdirAll(filepath.Dir(output), 0755))
+		return ioutil.WriteFile(output, []byte("app"), 0755)
+	}
+
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 1, runs, "the target should run the first time")
+
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 1, runs, "the target should be skipped when nothing changed")
+
+	// Reset the modification time, like a fresh checkout does
+	future := time.Now().Add(time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(src, "main.go"), future, future))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 1, runs, "the target should be skipped when only the modification time changed")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "pkg/lib.go"), []byte("package lib"), 0644))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 2, runs, "the target should run when an input changed")
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(src, "pkg/new.go"), []byte("package lib"), 0644))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 3, runs, "the target should run when an input was added")
+
+	require.NoError(t, os.Remove(output))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 4, runs, "the target should run when an output is missing")
+
+	require.NoError(t, ioutil.WriteFile(output, []byte("modified"), 0755))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 5, runs, "the target should run when an output changed")
+
+	require.NoError(t, Reset(opts))
+	require.NoError(t, Run(opts, build))
+	assert.Equal(t, 6, runs, "the target should run after it is reset")
+}
+
+func TestRun_Failed(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	defer os.RemoveAll(tmp)
+
+	opts := Options{
+		Name:     "test",
+		StateDir: tmp,
+	}
+
+	err = Run(opts, func() error { return errors.New("oops") })
+	require.EqualError(t, err, "oops")
+
+	changed, err := Changed(opts)
+	require.NoError(t, err)
+	assert.True(t, changed, "the state should not be saved when the target fails")
+}
+
+func TestRun_InputChangedWhileRunning(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "magex")
+	require.NoError(t, err, "could not create temp directory for test")
+	defer os.RemoveAll(tmp)
+
+	input := filepath.Join(tmp, "main.go")
+	require.NoError(t, ioutil.WriteFile(input, []byte("package main"), 0644))
+	opts := Options{
+		Name:     "build",
+		Inputs:   []string{input},
+		StateDir: filepath.Join(tmp, "state"),
+	}
+
+	err = Run(opts, func() error {
+		return ioutil.WriteFile(input, []byte("package changed"), 0644)
+	})
+	require.NoError(t, err)
+
+	changed, err := Changed(opts)
+	require.NoError(t, err)
+	assert.True(t, changed, "an input that changed while the target ran should not be recorded as built")
+}
+
+func TestChanged_InvalidName(t *testing.T) {
+	_, err := Changed(Options{Name: "../build"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid target name")
+}